@@ -0,0 +1,179 @@
+package bvtree
+
+import (
+    "math/bits"
+)
+
+/**
+ * Set algebra on two trees over the same universe. The bitvectors
+ * are combined a uint64 at a time, then the supporting structure is
+ * rebuilt from the result in a single pass. The With variants update
+ * the receiver in place, the others return a new tree.
+ */
+
+func (bvTree *BvFhTree) UnionWith(other *BvFhTree) error {
+    return bvTree.combine(bvTree, other, orWords)
+}
+
+func (bvTree *BvFhTree) IntersectWith(other *BvFhTree) error {
+    return bvTree.combine(bvTree, other, andWords)
+}
+
+func (bvTree *BvFhTree) DifferenceWith(other *BvFhTree) error {
+    return bvTree.combine(bvTree, other, andNotWords)
+}
+
+func (bvTree *BvFhTree) SymmetricDifferenceWith(other *BvFhTree) error {
+    return bvTree.combine(bvTree, other, xorWords)
+}
+
+func (bvTree *BvFhTree) Union(other *BvFhTree) (*BvFhTree, error) {
+    return bvTree.combined(other, orWords)
+}
+
+func (bvTree *BvFhTree) Intersection(other *BvFhTree) (*BvFhTree, error) {
+    return bvTree.combined(other, andWords)
+}
+
+func (bvTree *BvFhTree) Difference(other *BvFhTree) (*BvFhTree, error) {
+    return bvTree.combined(other, andNotWords)
+}
+
+func (bvTree *BvFhTree) SymmetricDifference(other *BvFhTree) (*BvFhTree, error) {
+    return bvTree.combined(other, xorWords)
+}
+
+func (bvTree *BvFhTree) combined(other *BvFhTree, op func(uint64, uint64) uint64) (*BvFhTree, error) {
+    if err := checkSameUniverse(bvTree.numBits, other.numBits); err != nil {
+        return nil, err
+    }
+    result := BuildBvFhTree(bvTree.numBits)
+    return result, result.combine(bvTree, other, op)
+}
+
+/**
+ * Sets the bitvector to op(a, b) for every uint64, and rebuilds
+ * the summary and counts.
+ */
+func (bvTree *BvFhTree) combine(a *BvFhTree, b *BvFhTree, op func(uint64, uint64) uint64) error {
+    if bvTree.readOnly {
+        return ErrReadOnly
+    }
+    if err := checkSameUniverse(a.numBits, b.numBits); err != nil {
+        return err
+    }
+    if err := checkSameUniverse(bvTree.numBits, a.numBits); err != nil {
+        return err
+    }
+
+    for i := range(bvTree.bitvector) {
+        bvTree.bitvector[i] = op(a.bitvector[i], b.bitvector[i])
+    }
+    bvTree.rebuild()
+    return nil
+}
+
+/**
+ * Recomputes the summary, the cluster counts and the count
+ * from the bitvector.
+ */
+func (bvTree *BvFhTree) rebuild() {
+    clear(bvTree.summary)
+    bvTree.count = 0
+    for sIdx := range(bvTree.clusterCounts) {
+        min, max := bvTree.childrenRange(uint64(sIdx))
+        c := popcountRange(bvTree.bitvector, min, max + 1)
+        bvTree.clusterCounts[sIdx] = c
+        bvTree.count += c
+        if c != 0 {
+            idx, off := offsets(uint64(sIdx))
+            bvTree.summary[idx] |= uint64(1 << (63 - off))
+        }
+    }
+}
+
+
+
+func (bvTree *BvTree) UnionWith(other *BvTree) error {
+    return bvTree.combine(bvTree, other, orWords)
+}
+
+func (bvTree *BvTree) IntersectWith(other *BvTree) error {
+    return bvTree.combine(bvTree, other, andWords)
+}
+
+func (bvTree *BvTree) DifferenceWith(other *BvTree) error {
+    return bvTree.combine(bvTree, other, andNotWords)
+}
+
+func (bvTree *BvTree) SymmetricDifferenceWith(other *BvTree) error {
+    return bvTree.combine(bvTree, other, xorWords)
+}
+
+func (bvTree *BvTree) Union(other *BvTree) (*BvTree, error) {
+    return bvTree.combined(other, orWords)
+}
+
+func (bvTree *BvTree) Intersection(other *BvTree) (*BvTree, error) {
+    return bvTree.combined(other, andWords)
+}
+
+func (bvTree *BvTree) Difference(other *BvTree) (*BvTree, error) {
+    return bvTree.combined(other, andNotWords)
+}
+
+func (bvTree *BvTree) SymmetricDifference(other *BvTree) (*BvTree, error) {
+    return bvTree.combined(other, xorWords)
+}
+
+func (bvTree *BvTree) combined(other *BvTree, op func(uint64, uint64) uint64) (*BvTree, error) {
+    if err := checkSameUniverse(bvTree.numBits, other.numBits); err != nil {
+        return nil, err
+    }
+    result := BuildBvTree(bvTree.numBits)
+    return result, result.combine(bvTree, other, op)
+}
+
+/**
+ * Sets the bitvector to op(a, b) for every uint64, and rebuilds
+ * the supporting binary tree and counts.
+ */
+func (bvTree *BvTree) combine(a *BvTree, b *BvTree, op func(uint64, uint64) uint64) error {
+    if err := checkSameUniverse(a.numBits, b.numBits); err != nil {
+        return err
+    }
+    if err := checkSameUniverse(bvTree.numBits, a.numBits); err != nil {
+        return err
+    }
+
+    for i := range(bvTree.bitvector) {
+        bvTree.bitvector[i] = op(a.bitvector[i], b.bitvector[i])
+    }
+    bvTree.rebuild()
+    return nil
+}
+
+/**
+ * Recomputes the supporting binary tree, the block counts and
+ * the count from the bitvector. Zero uint64s are skipped, and
+ * marking a path stops at the first node that's already set.
+ */
+func (bvTree *BvTree) rebuild() {
+    clear(bvTree.suptree)
+    clear(bvTree.blockCounts)
+    bvTree.count = 0
+    for idx, word := range(bvTree.bitvector) {
+        if word == 0 {
+            continue
+        }
+
+        c := uint64(bits.OnesCount64(word))
+        bvTree.count += c
+        bvTree.blockCounts[uint64(idx) / bvTree.blockUints] += c
+        for word != 0 {
+            b := uint64(bits.LeadingZeros64(word))
+            bvTree.words().markPath(uint64(idx) * 64 + b)
+            word &= ^uint64(1 << (63 - b))
+        }
+    }
+}
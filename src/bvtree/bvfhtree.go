@@ -2,6 +2,7 @@ package bvtree
 
 import (
     "fmt"
+    "iter"
 )
 
 /**
@@ -15,61 +16,51 @@ type BvFhTree struct {
 
     sqNumBits uint64
 
-    empty bool
+    // The number of members in the set.
+    count uint64
+
+    // The number of members in each cluster, for Rank and Select.
+    clusterCounts []uint64
 
     // Bit vector holding a summary tree of fixed height
     summary []uint64
 
     // Bit vector holding the actual values in the tree.
     bitvector []uint64
+
+    // Set when the bitvectors can't be written, like when they're
+    // mapped from a file.
+    readOnly bool
 }
 
 func (bvTree *BvFhTree) Min() uint64 {
-    if bvTree.empty {
-        panic("No min on an empty tree...")
-    }
-
-    idx := uint64(0)
-    for i := uint64(0); i < bvTree.sqNumBits; i++ {
-        if bvTree.hasSumBit(i) {
-            idx = i
-            break
-        }
+    min, ok := bvTree.MinOk()
+    if !ok {
+        panic(ErrEmpty)
     }
+    return min
+}
 
-    min, max := bvTree.childrenRange(idx)
-
-    for i := min; i <= max; i++ {
-        if bvTree.hasBvBit(i) {
-            return i
-        }
+func (bvTree *BvFhTree) MinOk() (uint64, bool) {
+    if bvTree.count == 0 {
+        return 0, false
     }
-
-    panic("Min didn't find anything...")
+    return bvTree.words().MinOk()
 }
 
 func (bvTree *BvFhTree) Max() uint64 {
-    if bvTree.empty {
-        panic("No min on an empty tree...")
-    }
-
-    idx := bvTree.sqNumBits
-    for i := bvTree.sqNumBits - 1; i >= uint64(0); i-- {
-        if bvTree.hasSumBit(i) {
-            idx = i
-            break
-        }
+    max, ok := bvTree.MaxOk()
+    if !ok {
+        panic(ErrEmpty)
     }
+    return max
+}
 
-    min, max := bvTree.childrenRange(idx)
-
-    for i := max; i >= min; i-- {
-        if bvTree.hasBvBit(i) {
-            return i
-        }
+func (bvTree *BvFhTree) MaxOk() (uint64, bool) {
+    if bvTree.count == 0 {
+        return 0, false
     }
-
-    panic("Min didn't find anything...")
+    return bvTree.words().MaxOk()
 }
 
 
@@ -80,33 +71,21 @@ func (bvTree *BvFhTree) Max() uint64 {
  * the min value.
  */
 func (bvTree *BvFhTree) Predecessor(n uint64) uint64 {
-
-    // First check the sibling range.
-    min, _ := bvTree.siblingRange(n)
-    for i := n - 1; i >= min; i-- {
-        if bvTree.hasBvBit(i) {
-            return i
-        }
-    }
-
-    // If we haven't found it yet, find the next bit in 
-    // the summary vector
-    sumToSearch := bvTree.sumIndex(n) - 1
-    for i := sumToSearch; i >= 0; i-- {
-        if bvTree.hasSumBit(i) {
-            sumToSearch = i
-            break
-        }
-    }
-
-    min, max := bvTree.childrenRange(sumToSearch)
-    for i := max; i >= min; i-- {
-        if bvTree.hasBvBit(i) {
-            return i
-        }
+    pred, ok := bvTree.PredecessorOk(n)
+    if !ok {
+        panic("There was a problem with predecessor.")
     }
+    return pred
+}
 
-    panic("There was a problem with predecessor.")
+/**
+ * Returns the number below n in the tree, and false if
+ * there is no such number.
+ */
+func (bvTree *BvFhTree) PredecessorOk(n uint64) (uint64, bool) {
+    // First check the sibling range, then the previous cluster in
+    // the summary.
+    return bvTree.words().PredecessorOk(n)
 }
 
 
@@ -117,33 +96,29 @@ func (bvTree *BvFhTree) Predecessor(n uint64) uint64 {
  * the max value.
  */
 func (bvTree *BvFhTree) Successor(n uint64) uint64 {
-
-    // First check the sibling range.
-    _, max := bvTree.siblingRange(n)
-    for i := n + 1; i <= max; i++ {
-        if bvTree.hasBvBit(i) {
-            return i
-        }
-    }
-
-    // If we haven't found it yet, find the next bit in 
-    // the summary vector
-    sumToSearch := bvTree.sumIndex(n) + 1
-    for i := sumToSearch; i < bvTree.sqNumBits; i++ {
-        if bvTree.hasSumBit(i) {
-            sumToSearch = i
-            break
-        }
+    succ, ok := bvTree.SuccessorOk(n)
+    if !ok {
+        panic("There was a problem with successor.")
     }
+    return succ
+}
 
-    min, max := bvTree.childrenRange(sumToSearch)
-    for i := min; i <= max; i++ {
-        if bvTree.hasBvBit(i) {
-            return i
-        }
-    }
+/**
+ * Returns the number above n in the tree, and false if
+ * there is no such number.
+ */
+func (bvTree *BvFhTree) SuccessorOk(n uint64) (uint64, bool) {
+    // First check the sibling range, then the next cluster in
+    // the summary.
+    return bvTree.words().SuccessorOk(n)
+}
 
-    panic("There was a problem with successor.")
+/**
+ * Returns the tree's slices as FhWords, which has the code for
+ * the layout.
+ */
+func (bvTree *BvFhTree) words() FhWords {
+    return FhWords{bvTree.sqNumBits, bvTree.clusterCounts, bvTree.summary, bvTree.bitvector}
 }
 
 
@@ -152,45 +127,202 @@ func (bvTree *BvFhTree) Successor(n uint64) uint64 {
  * returns true if the bvTree contains the given uint64.
  */
 func (bvTree *BvFhTree) Contains(n uint64) bool {
-    idx, off := offsets(n)
-    b := uint64(1 << (63 - off))
-    if (bvTree.bitvector[idx] & b) == 0 {
+    return n < bvTree.numBits && bvTree.words().Contains(n)
+}
+
+/**
+ * Inserts the integer n into the bvTree, returns true if
+ * it wasn't already there.
+ */
+func (bvTree *BvFhTree) Insert(n uint64) bool {
+    if bvTree.readOnly {
+        panic(ErrReadOnly)
+    }
+    if err := CheckUniverse(n, bvTree.numBits); err != nil {
+        panic(err)
+    }
+
+    if !bvTree.words().Insert(n) {
+        return false
+    }
+    bvTree.count++
+    return true
+}
+
+/**
+ * Removes the integer n from the bvTree, returns true if
+ * it was there.
+ */
+func (bvTree *BvFhTree) Remove(n uint64) bool {
+    if bvTree.readOnly {
+        panic(ErrReadOnly)
+    }
+    if n >= bvTree.numBits || !bvTree.words().Remove(n) {
         return false
     }
+    bvTree.count--
     return true
 }
 
 /**
- * Inserts the integer n into the bvTree.
+ * returns true if the bvTree contains n, or an error if n
+ * is outside of the universe.
+ */
+func (bvTree *BvFhTree) ContainsChecked(n uint64) (bool, error) {
+    if err := CheckUniverse(n, bvTree.numBits); err != nil {
+        return false, err
+    }
+    return bvTree.Contains(n), nil
+}
+
+/**
+ * Inserts n, returning an error instead of panicking if n
+ * is outside of the universe or the bvTree is read only.
  */
-func (bvTree *BvFhTree) Insert(n uint64) {
-    // Add the bit to the data.
-    idx, off := offsets(n)
-    b := uint64(1 << (63 - off))
-    bvTree.bitvector[idx] |= b
+func (bvTree *BvFhTree) InsertChecked(n uint64) (bool, error) {
+    if err := CheckUniverse(n, bvTree.numBits); err != nil {
+        return false, err
+    }
+    if bvTree.readOnly {
+        return false, ErrReadOnly
+    }
+    return bvTree.Insert(n), nil
+}
 
-    // Update the supporting binary tree.
+/**
+ * Removes n, returning an error if n is outside of the universe
+ * or the bvTree is read only.
+ */
+func (bvTree *BvFhTree) RemoveChecked(n uint64) (bool, error) {
+    if err := CheckUniverse(n, bvTree.numBits); err != nil {
+        return false, err
+    }
+    if bvTree.readOnly {
+        return false, ErrReadOnly
+    }
+    return bvTree.Remove(n), nil
+}
+
+/**
+ * Returns the number of members in the bvTree.
+ */
+func (bvTree *BvFhTree) Len() uint64 {
+    return bvTree.count
+}
+
+/**
+ * Returns the size of the universe, after rounding up
+ * the number of bits the tree was built with.
+ */
+func (bvTree *BvFhTree) Universe() uint64 {
+    return bvTree.numBits
+}
+
+/**
+ * Returns the number of members less than n, adding up the
+ * counts of the clusters before n then counting the bits of
+ * n's own cluster.
+ */
+func (bvTree *BvFhTree) Rank(n uint64) uint64 {
+    if n >= bvTree.numBits {
+        return bvTree.count
+    }
+
+    result := uint64(0)
     sIdx := bvTree.sumIndex(n)
-    idx, off = offsets(sIdx)
-    b = uint64(1 << (63 - off))
-    bvTree.summary[idx] |= b
-    bvTree.empty = false
+    for _, c := range(bvTree.clusterCounts[:sIdx]) {
+        result += c
+    }
+    min, _ := bvTree.siblingRange(n)
+    return result + popcountRange(bvTree.bitvector, min, n)
+}
+
+/**
+ * Returns the k-th smallest member, counting from 0, and false
+ * if there are k or fewer members.
+ */
+func (bvTree *BvFhTree) Select(k uint64) (uint64, bool) {
+    if k >= bvTree.count {
+        return 0, false
+    }
+
+    // Find the cluster, then the bit inside it.
+    sIdx := uint64(0)
+    for k >= bvTree.clusterCounts[sIdx] {
+        k -= bvTree.clusterCounts[sIdx]
+        sIdx++
+    }
+    min, _ := bvTree.childrenRange(sIdx)
+    return selectBit(bvTree.bitvector, min, k), true
 }
 
-func (bvTree *BvFhTree) Remove(n uint64) {
-    // Rmove from the bitvector
-    idx, off := offsets(n)
-    b := ^uint64(1 << (63 - off))
-    bvTree.bitvector[idx] &= b
+/**
+ * Returns an iterator over the members in ascending order.
+ */
+func (bvTree *BvFhTree) All() iter.Seq[uint64] {
+    return bvTree.Range(0, bvTree.numBits)
+}
 
-    min, max := bvTree.siblingRange(n)
+/**
+ * Returns an iterator over the members in descending order.
+ */
+func (bvTree *BvFhTree) Backward() iter.Seq[uint64] {
+    return func(yield func(uint64) bool) {
+        hi := bvTree.sqNumBits
+        for {
+            // Skip back to the previous non empty cluster.
+            idx, ok := prevSetBit(bvTree.summary, 0, hi)
+            if !ok {
+                return
+            }
+
+            min, max := bvTree.childrenRange(idx)
+            for n := range(descendBits(bvTree.bitvector, min, max + 1)) {
+                if !yield(n) {
+                    return
+                }
+            }
+            hi = idx
+        }
+    }
+}
 
-    if bvTree.emptyRange(min, max) {
-        idx, off := offsets(bvTree.sumIndex(n))
-        b = ^uint64(1 << (63 - off))
-        bvTree.summary[idx] &= b
+/**
+ * Returns an iterator over the members in [lo, hi), in ascending
+ * order. Empty clusters are skipped using the summary, and the
+ * bitvector is walked a uint64 at a time.
+ */
+func (bvTree *BvFhTree) Range(lo uint64, hi uint64) iter.Seq[uint64] {
+    if hi > bvTree.numBits {
+        hi = bvTree.numBits
     }
 
+    return func(yield func(uint64) bool) {
+        // A cursor of its own, so the iterator can be ranged over again.
+        cur := lo
+        for cur < hi {
+            // Skip ahead to the next non empty cluster.
+            idx, ok := nextSetBit(bvTree.summary, bvTree.sumIndex(cur), bvTree.sqNumBits)
+            if !ok {
+                return
+            }
+
+            min, max := bvTree.childrenRange(idx)
+            if min > cur {
+                cur = min
+            }
+            end := max + 1
+            if end > hi {
+                end = hi
+            }
+            for n := range(ascendBits(bvTree.bitvector, cur, end)) {
+                if !yield(n) {
+                    return
+                }
+            }
+            cur = max + 1
+        }
+    }
 }
 
 func getFhNumUints(numBits uint64) (uint64, uint64) {
@@ -201,41 +333,43 @@ func getFhNumUints(numBits uint64) (uint64, uint64) {
     if result * result <= uint64(64) {
         return 1, 1
     }
-    return (result / uint64(64)), (result * result / uint64(64))
+    // The summary needs a whole uint64 even when there are fewer
+    // than 64 clusters.
+    return (result + uint64(63)) / uint64(64), (result * result / uint64(64))
 }
 
 func BuildBvFhTree(numBits uint64) *BvFhTree {
+    return buildBvFhTree(numBits, numBits)
+}
+
+/**
+ * Builds a tree of numBits, with room in its slices for a tree of
+ * capBits so it can be Reset up to that without allocating.
+ */
+func buildBvFhTree(numBits uint64, capBits uint64) *BvFhTree {
     result := BvFhTree{}
 
     // The number of uints we need is size / 64
     numSumUints, numBvUints := getFhNumUints(numBits)
+    capSumUints, capBvUints := getFhNumUints(max(numBits, capBits))
 
-    fmt.Printf("building a tree with %d/%d uint64s.\n", numSumUints, numBvUints)
     result.numBits = numBvUints * uint64(64)
     result.sqNumBits = getRoot(result.numBits)
-    result.bitvector = make([]uint64, numBvUints)
-    result.summary = make([]uint64, numSumUints)
+    result.bitvector = make([]uint64, numBvUints, capBvUints)
+    result.summary = make([]uint64, numSumUints, capSumUints)
+    result.clusterCounts = make([]uint64, result.sqNumBits, getRoot(capBvUints * 64))
     return &result
 }
 
+/**
+ * Returns the range [min, max) of the cluster holding n.
+ */
 func (bvTree *BvFhTree) siblingRange(n uint64) (uint64, uint64) {
     min := (n / bvTree.sqNumBits) * bvTree.sqNumBits
     max := min + bvTree.sqNumBits
     return min, max
 }
 
-func (bvTree *BvFhTree) emptyRange(min uint64, max uint64) bool {
-    for i := min; i <= max; i++ {
-        idx, off := offsets(i)
-        b := uint64(1 << (63 - off))
-        val := bvTree.bitvector[idx] & b
-        if val != 0 {
-            return false
-        }
-    }
-    return true
-}
-
 // Return true if the supporting tree has the bit.
 func (bvTree *BvFhTree) hasSumBit(pos uint64) bool {
     idx, off := offsets(pos)
@@ -293,14 +427,5 @@ func (bvTree *BvFhTree) checkBit(n uint64) {
 }
 
 func (bvTree *BvFhTree) DbgPrint() {
-    fmt.Println("DbgPrint: ")
-    fmt.Println("suptree")
-    for _, val := range(bvTree.summary) {
-        dbgPrintBin(val)
-    }
-    fmt.Println("\nbitvector")
-    for _, val := range(bvTree.bitvector) {
-        dbgPrintBin(val)
-    }
-    fmt.Println(" ")
+    bvTree.words().DbgPrint()
 }
@@ -0,0 +1,392 @@
+package bvtree
+
+import (
+    "fmt"
+    "iter"
+    "math/bits"
+)
+
+/**
+ * BvMlTree is a struct that holds a bitvector representation
+ * of a set of integeres between 0 and n, with a superimposed
+ * tree of 64-way fanout.
+ *
+ * Every level is a bitvector with one bit per uint64 of the level
+ * below it, set when that uint64 is non zero, up to a top level
+ * of a single uint64. That makes the height ceil(log64 u), at most
+ * 11 for a universe of 2^64, and every query a handful of word
+ * operations per level.
+ */
+type BvMlTree struct {
+
+    // The number of bits in the bitvector, size of the universe.
+    // A universe of 2^64 wraps around to 0.
+    numBits uint64
+
+    // The number of members in the set.
+    count uint64
+
+    // levels[0] is the bitvector holding the actual values in the
+    // tree, levels[len(levels) - 1] is a single uint64.
+    levels [][]uint64
+}
+
+func (bvTree *BvMlTree) Min() uint64 {
+    min, ok := bvTree.MinOk()
+    if !ok {
+        panic(ErrEmpty)
+    }
+    return min
+}
+
+func (bvTree *BvMlTree) MinOk() (uint64, bool) {
+    if bvTree.count == 0 {
+        return 0, false
+    }
+    return bvTree.descendMin(len(bvTree.levels), 0), true
+}
+
+func (bvTree *BvMlTree) Max() uint64 {
+    max, ok := bvTree.MaxOk()
+    if !ok {
+        panic(ErrEmpty)
+    }
+    return max
+}
+
+func (bvTree *BvMlTree) MaxOk() (uint64, bool) {
+    if bvTree.count == 0 {
+        return 0, false
+    }
+    return bvTree.descendMax(len(bvTree.levels), 0), true
+}
+
+
+
+/**
+ * Returns the number below n in the tree.
+ * Assumes that the number passed in is greater than
+ * the min value.
+ */
+func (bvTree *BvMlTree) Predecessor(n uint64) uint64 {
+    pred, ok := bvTree.PredecessorOk(n)
+    if !ok {
+        panic("There was a problem with predecessor.")
+    }
+    return pred
+}
+
+/**
+ * Returns the number below n in the tree, and false if
+ * there is no such number.
+ */
+func (bvTree *BvMlTree) PredecessorOk(n uint64) (uint64, bool) {
+    // Everything in the tree is below n.
+    if !bvTree.inUniverse(n) {
+        return bvTree.MaxOk()
+    }
+
+    // Climb until a level has a bit before our position, then
+    // follow the largest bits back down.
+    pos := n
+    for l := 0; l < len(bvTree.levels); l++ {
+        idx, off := offsets(pos)
+        word := bvTree.levels[l][idx] & ^(^uint64(0) >> off)
+        if word != 0 {
+            pos = idx * 64 + uint64(63 - bits.TrailingZeros64(word))
+            return bvTree.descendMax(l, pos), true
+        }
+        pos = idx
+    }
+    return 0, false
+}
+
+
+
+/**
+ * Returns the number above n in the tree.
+ * Assumes that the number passed in is less than
+ * the max value.
+ */
+func (bvTree *BvMlTree) Successor(n uint64) uint64 {
+    succ, ok := bvTree.SuccessorOk(n)
+    if !ok {
+        panic("There was a problem with successor.")
+    }
+    return succ
+}
+
+/**
+ * Returns the number above n in the tree, and false if
+ * there is no such number.
+ */
+func (bvTree *BvMlTree) SuccessorOk(n uint64) (uint64, bool) {
+    if !bvTree.inUniverse(n) {
+        return 0, false
+    }
+
+    // Climb until a level has a bit after our position, then
+    // follow the smallest bits back down.
+    pos := n
+    for l := 0; l < len(bvTree.levels); l++ {
+        idx, off := offsets(pos)
+        word := bvTree.levels[l][idx] & (^uint64(0) >> (off + 1))
+        if word != 0 {
+            pos = idx * 64 + uint64(bits.LeadingZeros64(word))
+            return bvTree.descendMin(l, pos), true
+        }
+        pos = idx
+    }
+    return 0, false
+}
+
+/**
+ * Given a set bit at pos in level l, follows the smallest
+ * set bits down to the bitvector. Passing len(levels) starts
+ * from the single uint64 at the top.
+ */
+func (bvTree *BvMlTree) descendMin(l int, pos uint64) uint64 {
+    for l > 0 {
+        l--
+        pos = pos * 64 + uint64(bits.LeadingZeros64(bvTree.levels[l][pos]))
+    }
+    return pos
+}
+
+/**
+ * Given a set bit at pos in level l, follows the largest
+ * set bits down to the bitvector.
+ */
+func (bvTree *BvMlTree) descendMax(l int, pos uint64) uint64 {
+    for l > 0 {
+        l--
+        pos = pos * 64 + uint64(63 - bits.TrailingZeros64(bvTree.levels[l][pos]))
+    }
+    return pos
+}
+
+
+
+/**
+ * returns true if the bvTree contains the given uint64.
+ */
+func (bvTree *BvMlTree) Contains(n uint64) bool {
+    if !bvTree.inUniverse(n) {
+        return false
+    }
+
+    idx, off := offsets(n)
+    return (bvTree.levels[0][idx] & uint64(1 << (63 - off))) != 0
+}
+
+/**
+ * Inserts the integer n into the bvTree, returns true if
+ * it wasn't already there.
+ */
+func (bvTree *BvMlTree) Insert(n uint64) bool {
+    if err := CheckUniverse(n, bvTree.numBits); err != nil {
+        panic(err)
+    }
+    if bvTree.Contains(n) {
+        return false
+    }
+
+    // Set the bit at each level, stopping at the first uint64
+    // that was already non zero, its parent bit is already set.
+    pos := n
+    for l := 0; l < len(bvTree.levels); l++ {
+        idx, off := offsets(pos)
+        word := bvTree.levels[l][idx]
+        bvTree.levels[l][idx] = word | uint64(1 << (63 - off))
+        if word != 0 {
+            break
+        }
+        pos = idx
+    }
+    bvTree.count++
+    return true
+}
+
+/**
+ * Removes the integer n from the bvTree, returns true if
+ * it was there.
+ */
+func (bvTree *BvMlTree) Remove(n uint64) bool {
+    if !bvTree.Contains(n) {
+        return false
+    }
+
+    // Clear the bit at each level, stopping at the first uint64
+    // that still has something in it.
+    pos := n
+    for l := 0; l < len(bvTree.levels); l++ {
+        idx, off := offsets(pos)
+        bvTree.levels[l][idx] &= ^uint64(1 << (63 - off))
+        if bvTree.levels[l][idx] != 0 {
+            break
+        }
+        pos = idx
+    }
+    bvTree.count--
+    return true
+}
+
+/**
+ * returns true if the bvTree contains n, or an error if n
+ * is outside of the universe.
+ */
+func (bvTree *BvMlTree) ContainsChecked(n uint64) (bool, error) {
+    if err := CheckUniverse(n, bvTree.numBits); err != nil {
+        return false, err
+    }
+    return bvTree.Contains(n), nil
+}
+
+/**
+ * Inserts n, returning an error instead of panicking if n
+ * is outside of the universe.
+ */
+func (bvTree *BvMlTree) InsertChecked(n uint64) (bool, error) {
+    if err := CheckUniverse(n, bvTree.numBits); err != nil {
+        return false, err
+    }
+    return bvTree.Insert(n), nil
+}
+
+/**
+ * Removes n, returning an error if n is outside of the universe.
+ */
+func (bvTree *BvMlTree) RemoveChecked(n uint64) (bool, error) {
+    if err := CheckUniverse(n, bvTree.numBits); err != nil {
+        return false, err
+    }
+    return bvTree.Remove(n), nil
+}
+
+/**
+ * Returns the number of members in the bvTree.
+ */
+func (bvTree *BvMlTree) Len() uint64 {
+    return bvTree.count
+}
+
+/**
+ * Returns the size of the universe, after rounding up
+ * the number of bits the tree was built with. A tree
+ * over all 2^64 keys returns 0.
+ */
+func (bvTree *BvMlTree) Universe() uint64 {
+    return bvTree.numBits
+}
+
+/**
+ * Returns an iterator over the members in ascending order.
+ */
+func (bvTree *BvMlTree) All() iter.Seq[uint64] {
+    return bvTree.ascend(0, bvTree.numBits - 1)
+}
+
+/**
+ * Returns an iterator over the members in descending order. Each
+ * uint64 of the bitvector is drained before climbing the levels to
+ * jump to the previous non zero one.
+ */
+func (bvTree *BvMlTree) Backward() iter.Seq[uint64] {
+    return func(yield func(uint64) bool) {
+        n, ok := bvTree.MaxOk()
+        for ok {
+            idx, off := offsets(n)
+            word := bvTree.levels[0][idx] & ^(^uint64(0) >> (off + 1))
+            for word != 0 {
+                b := uint64(63 - bits.TrailingZeros64(word))
+                if !yield(idx * 64 + b) {
+                    return
+                }
+                word &= ^uint64(1 << (63 - b))
+            }
+            n, ok = bvTree.PredecessorOk(idx * 64)
+        }
+    }
+}
+
+/**
+ * Returns an iterator over the members in [lo, hi), in
+ * ascending order.
+ */
+func (bvTree *BvMlTree) Range(lo uint64, hi uint64) iter.Seq[uint64] {
+    if hi == 0 {
+        return func(yield func(uint64) bool) {}
+    }
+    return bvTree.ascend(lo, hi - 1)
+}
+
+/**
+ * Returns an iterator over the members in [lo, hi]. Each uint64
+ * of the bitvector is drained before climbing the levels to jump
+ * to the next non zero one.
+ */
+func (bvTree *BvMlTree) ascend(lo uint64, hi uint64) iter.Seq[uint64] {
+    return func(yield func(uint64) bool) {
+        n, ok := lo, bvTree.Contains(lo)
+        if !ok {
+            n, ok = bvTree.SuccessorOk(lo)
+        }
+        for ok {
+            idx, off := offsets(n)
+            word := bvTree.levels[0][idx] & (^uint64(0) >> off)
+            for word != 0 {
+                b := uint64(bits.LeadingZeros64(word))
+                if idx * 64 + b > hi || !yield(idx * 64 + b) {
+                    return
+                }
+                word &= ^uint64(1 << (63 - b))
+            }
+            n, ok = bvTree.SuccessorOk(idx * 64 + 63)
+        }
+    }
+}
+
+func (bvTree *BvMlTree) inUniverse(n uint64) bool {
+    return bvTree.numBits == 0 || n < bvTree.numBits
+}
+
+/**
+ * Returns the number of uint64s needed at each level, from
+ * the bitvector up to the single uint64 at the top.
+ */
+func getMlNumUints(numBits uint64) []uint64 {
+    numUints := numBits / 64
+    if numBits % 64 != 0 || numUints == 0 {
+        numUints++
+    }
+
+    result := []uint64{numUints}
+    for numUints > 1 {
+        numUints = (numUints + 63) / 64
+        result = append(result, numUints)
+    }
+    return result
+}
+
+func BuildBvMlTree(numBits uint64) *BvMlTree {
+    result := BvMlTree{}
+
+    levelUints := getMlNumUints(numBits)
+    result.numBits = levelUints[0] * uint64(64)
+    result.levels = make([][]uint64, len(levelUints))
+    for l, numUints := range(levelUints) {
+        result.levels[l] = make([]uint64, numUints)
+    }
+    return &result
+}
+
+func (bvTree *BvMlTree) DbgPrint() {
+    fmt.Println("DbgPrint: ")
+    for l := len(bvTree.levels) - 1; l >= 0; l-- {
+        fmt.Printf("level %d\n", l)
+        for _, val := range(bvTree.levels[l]) {
+            dbgPrintBin(val)
+        }
+    }
+    fmt.Println(" ")
+}
@@ -2,6 +2,7 @@ package bvtree
 
 import (
     "fmt"
+    "iter"
 //    "strconv"
 )
 
@@ -14,6 +15,14 @@ type BvTree struct {
     // The number of bits in the bitvector, size of the universe.
     numBits uint64
 
+    // The number of members in the set.
+    count uint64
+
+    // The number of members in each block of blockUints uint64s
+    // of the bitvector, for Rank and Select.
+    blockUints uint64
+    blockCounts []uint64
+
     // Bit vector holding the supporting binary tree.
     suptree []uint64
 
@@ -21,76 +30,28 @@ type BvTree struct {
     bitvector []uint64
 }
 
-func (bvTree *BvTree) zeroRoot() bool {
-    fb := bvTree.suptree[0] & uint64(1 << 63)
-    return fb == 0
-}
-
 func (bvTree *BvTree) Min() uint64 {
-    cPos := uint64(0)
-    if bvTree.zeroRoot() {
-        return 0
-    }
-
-    for cPos < bvTree.llIndex() {
-        rPos := rightIndex(cPos)
-        lPos := leftIndex(cPos)
-        lIdx, lOff := offsets(lPos)
-
-        lVal := bvTree.suptree[lIdx] & uint64(1 << (63 - lOff))
-
-        if lVal != 0 {
-            cPos = lPos
-        } else {
-            cPos = rPos
-        }
-    }
-
-    // Now that we're outside that loop, we need to 
-    // reach into the bitvector.
-    lPos, rPos := bvTree.bvIndices(cPos)
-    lIdx, lOff := offsets(lPos)
-    lVal := bvTree.bitvector[lIdx] & uint64(1 << (63 - lOff))
-
-    if lVal != 0 {
-        return lPos
+    min, ok := bvTree.MinOk()
+    if !ok {
+        panic(ErrEmpty)
     }
+    return min
+}
 
-    return rPos
+func (bvTree *BvTree) MinOk() (uint64, bool) {
+    return bvTree.words().MinOk()
 }
 
 func (bvTree *BvTree) Max() uint64 {
-    cPos := uint64(0)
-    if bvTree.zeroRoot() {
-        return 0
-    }
-
-    for cPos < bvTree.llIndex() {
-        rPos := rightIndex(cPos)
-        lPos := leftIndex(cPos)
-        rIdx, rOff := offsets(rPos)
-
-        rVal := bvTree.suptree[rIdx] & uint64(1 << (63 - rOff))
-
-        if rVal != 0 {
-            cPos = rPos
-        } else {
-            cPos = lPos
-        }
-
-    }
-
-    // Now that we're outside that loop, we need to 
-    // reach into the bitvector.
-    lPos, rPos := bvTree.bvIndices(cPos)
-    rIdx, rOff := offsets(rPos)
-    rVal := bvTree.bitvector[rIdx] & uint64(1 << (63 - rOff))
-
-    if rVal != 0 {
-        return rPos
+    max, ok := bvTree.MaxOk()
+    if !ok {
+        panic(ErrEmpty)
     }
+    return max
+}
 
-    return lPos
+func (bvTree *BvTree) MaxOk() (uint64, bool) {
+    return bvTree.words().MaxOk()
 }
 
 
@@ -101,46 +62,19 @@ func (bvTree *BvTree) Max() uint64 {
  * the min value.
  */
 func (bvTree *BvTree) Predecessor(n uint64) uint64 {
-    treePos := bvTree.supIndex(n)
-    goingUp := true
-
-    //Now, the normal case, we have to keep searching up the tree.
-    for treePos <= bvTree.maxLlIndex() {
-        //fmt.Printf("treePos %d\n", treePos)
-        // If we're at the lowest level, check whether the right child has a bit
-        // and make sure that we're not just returning n.
-        if bvTree.inLowestLevel(treePos) {
-        lPos, rPos := bvTree.bvIndices(treePos)
-            if rPos < n && bvTree.hasBvBit(rPos) {
-                return rPos
-            } else if lPos < n && bvTree.hasBvBit(lPos) {
-                return lPos
-            }
-        }
-
-        // If we didn't find the successor, we need to traverse the tree.
-
-        if goingUp {
-            nextLeftPos := leftIndex(parentIndex(treePos))
-
-            if nextLeftPos != treePos  && bvTree.hasStBit(nextLeftPos) {
-                treePos = nextLeftPos
-                goingUp = false
-            } else {
-                treePos = parentIndex(treePos)
-            }
-        } else {
-            // When going down the tree, just look right, if nothing, go left
-            nextRightPos, nextLeftPos := bvTree.childrenIndices(treePos)
-            if bvTree.hasStBit(nextLeftPos) {
-                treePos = nextLeftPos
-            } else {
-                treePos = nextRightPos
-            }
-        }
+    pred, ok := bvTree.PredecessorOk(n)
+    if !ok {
+        panic("There was a problem with predecessor.")
     }
+    return pred
+}
 
-    panic("There was a problem with predecessor.")
+/**
+ * Returns the number below n in the tree, and false if
+ * there is no such number.
+ */
+func (bvTree *BvTree) PredecessorOk(n uint64) (uint64, bool) {
+    return bvTree.words().PredecessorOk(n)
 }
 
 
@@ -151,46 +85,27 @@ func (bvTree *BvTree) Predecessor(n uint64) uint64 {
  * the max value.
  */
 func (bvTree *BvTree) Successor(n uint64) uint64 {
-    treePos := bvTree.supIndex(n)
-    goingUp := true
-
-    //Now, the normal case, we have to keep searching up the tree.
-    for treePos <= bvTree.maxLlIndex() {
-        //fmt.Printf("treePos %d\n", treePos)
-        // If we're at the lowest level, check whether the right child has a bit
-        // and make sure that we're not just returning n.
-        if bvTree.inLowestLevel(treePos) {
-        lPos, rPos := bvTree.bvIndices(treePos)
-            if lPos > n && bvTree.hasBvBit(lPos) {
-                return lPos
-            } else if rPos > n && bvTree.hasBvBit(rPos) {
-                return rPos
-            }
-        }
-
-        // If we didn't find the successor, we need to traverse the tree.
-
-        if goingUp {
-            nextRightPos := rightIndex(parentIndex(treePos))
-
-            if nextRightPos != treePos  && bvTree.hasStBit(nextRightPos) {
-                treePos = nextRightPos
-                goingUp = false
-            } else {
-                treePos = parentIndex(treePos)
-            }
-        } else {
-            // When going down the tree, just look right, if nothing, go left
-            nextRightPos, nextLeftPos := bvTree.childrenIndices(treePos)
-            if bvTree.hasStBit(nextRightPos) {
-                treePos = nextRightPos
-            } else {
-                treePos = nextLeftPos
-            }
-        }
+    succ, ok := bvTree.SuccessorOk(n)
+    if !ok {
+        panic("There was a problem with successor.")
     }
+    return succ
+}
+
+/**
+ * Returns the number above n in the tree, and false if
+ * there is no such number.
+ */
+func (bvTree *BvTree) SuccessorOk(n uint64) (uint64, bool) {
+    return bvTree.words().SuccessorOk(n)
+}
 
-    panic("There was a problem with successor.")
+/**
+ * Returns the tree's slices as BvWords, which has the code for
+ * the supporting tree.
+ */
+func (bvTree *BvTree) words() BvWords {
+    return BvWords{bvTree.suptree, bvTree.bitvector}
 }
 
 
@@ -199,79 +114,147 @@ func (bvTree *BvTree) Successor(n uint64) uint64 {
  * returns true if the bvTree contains the given uint64.
  */
 func (bvTree *BvTree) Contains(n uint64) bool {
-    idx, off := offsets(n)
-    b := uint64(1 << (63 - off))
-    if (bvTree.bitvector[idx] & b) == 0 {
+    return n < bvTree.numBits && bvTree.words().Contains(n)
+}
+
+/**
+ * Inserts the integer n into the bvTree, returns true if
+ * it wasn't already there.
+ */
+func (bvTree *BvTree) Insert(n uint64) bool {
+    if err := CheckUniverse(n, bvTree.numBits); err != nil {
+        panic(err)
+    }
+
+    if !bvTree.words().Insert(n) {
+        return false
+    }
+    bvTree.count++
+    bvTree.blockCounts[(n / 64) / bvTree.blockUints]++
+    return true
+}
+
+
+/**
+ * Removes the integer n from the bvTree, returns true if
+ * it was there.
+ */
+func (bvTree *BvTree) Remove(n uint64) bool {
+    if n >= bvTree.numBits || !bvTree.words().Remove(n) {
         return false
     }
+    bvTree.count--
+    bvTree.blockCounts[(n / 64) / bvTree.blockUints]--
     return true
 }
 
 /**
- * Inserts the integer n into the bvTree.
+ * returns true if the bvTree contains n, or an error if n
+ * is outside of the universe.
+ */
+func (bvTree *BvTree) ContainsChecked(n uint64) (bool, error) {
+    if err := CheckUniverse(n, bvTree.numBits); err != nil {
+        return false, err
+    }
+    return bvTree.Contains(n), nil
+}
+
+/**
+ * Inserts n, returning an error instead of panicking if n
+ * is outside of the universe.
+ */
+func (bvTree *BvTree) InsertChecked(n uint64) (bool, error) {
+    if err := CheckUniverse(n, bvTree.numBits); err != nil {
+        return false, err
+    }
+    return bvTree.Insert(n), nil
+}
+
+/**
+ * Removes n, returning an error if n is outside of the universe.
  */
-func (bvTree *BvTree) Insert(n uint64) {
-    // Add the bit to the data.
-    idx, off := offsets(n)
-    b := uint64(1 << (63 - off))
-    bvTree.bitvector[idx] |= b
-
-    // Update the supporting binary tree.
-    sIdx := bvTree.supIndex(n)
-    for sIdx > 0 {
-        idx, off = offsets(sIdx)
-        b = uint64(1 << (63 - off))
-        bvTree.suptree[idx] |= b
-        sIdx = parentIndex(sIdx)
+func (bvTree *BvTree) RemoveChecked(n uint64) (bool, error) {
+    if err := CheckUniverse(n, bvTree.numBits); err != nil {
+        return false, err
     }
-    bvTree.suptree[0] |= (1 << 63)
+    return bvTree.Remove(n), nil
 }
 
-func (bvTree *BvTree) Remove(n uint64) {
-    // Rmove from the bitvector
-    idx, off := offsets(n)
-    b := ^uint64(1 << (63 - off))
-    bvTree.bitvector[idx] &= b
+/**
+ * Returns the number of members in the bvTree.
+ */
+func (bvTree *BvTree) Len() uint64 {
+    return bvTree.count
+}
 
-    cIdx := bvTree.supIndex(n)
-    idx, off = offsets(cIdx)
-    b = ^uint64(1 << (63 - off))
+/**
+ * Returns the size of the universe, after rounding up
+ * the number of bits the tree was built with.
+ */
+func (bvTree *BvTree) Universe() uint64 {
+    return bvTree.numBits
+}
+
+/**
+ * Returns the number of members less than n, adding up the
+ * counts of the blocks before n then counting the bits of
+ * n's own block.
+ */
+func (bvTree *BvTree) Rank(n uint64) uint64 {
+    if n >= bvTree.numBits {
+        return bvTree.count
+    }
 
-    rIdx, rOff := offsets((n / 2) * 2)
-    lIdx, lOff := offsets((n / 2) * 2 + 1)
+    result := uint64(0)
+    block := (n / 64) / bvTree.blockUints
+    for _, c := range(bvTree.blockCounts[:block]) {
+        result += c
+    }
+    return result + popcountRange(bvTree.bitvector, block * bvTree.blockUints * 64, n)
+}
 
-    rVal := bvTree.bitvector[rIdx] & uint64(1 << (63 - rOff))
-    lVal := bvTree.bitvector[lIdx] & uint64(1 << (63 - lOff))
-    if (rVal == 0 && lVal == 0) {
-        bvTree.suptree[idx] &= b
+/**
+ * Returns the k-th smallest member, counting from 0, and false
+ * if there are k or fewer members.
+ */
+func (bvTree *BvTree) Select(k uint64) (uint64, bool) {
+    if k >= bvTree.count {
+        return 0, false
     }
 
-    cIdx = parentIndex(cIdx)
-    for cIdx > 0 {
-        idx, off := offsets(cIdx)
-        // bit to clear.
-        b = ^uint64(1 << (63 - off))
-
-        // Values of left and right children.
-        rPos := rightIndex(cIdx)
-        lPos := leftIndex(cIdx)
-        rIdx, rOff = offsets(rPos)
-        lIdx, lOff = offsets(lPos)
-
-        rVal := bvTree.suptree[rIdx] & uint64(1 << (63 - rOff))
-        lVal := bvTree.suptree[lIdx] & uint64(1 << (63 - lOff))
-        if (rVal == 0 && lVal == 0) {
-            bvTree.suptree[idx] &= b
-        }
-
-        cIdx = parentIndex(cIdx)
+    // Find the block, then the bit inside it.
+    block := uint64(0)
+    for k >= bvTree.blockCounts[block] {
+        k -= bvTree.blockCounts[block]
+        block++
     }
+    return selectBit(bvTree.bitvector, block * bvTree.blockUints * 64, k), true
+}
+
+/**
+ * Returns an iterator over the members in ascending order,
+ * walking the bitvector a uint64 at a time.
+ */
+func (bvTree *BvTree) All() iter.Seq[uint64] {
+    return ascendBits(bvTree.bitvector, 0, bvTree.numBits)
+}
+
+/**
+ * Returns an iterator over the members in descending order.
+ */
+func (bvTree *BvTree) Backward() iter.Seq[uint64] {
+    return descendBits(bvTree.bitvector, 0, bvTree.numBits)
+}
 
-    // Clear out the root node if theres no data left.
-    fb := bvTree.suptree[0]
-    if fb == (uint64(1 << 63))  {
-        bvTree.suptree[0] = 0
+/**
+ * Returns an iterator over the members in [lo, hi), in
+ * ascending order.
+ */
+func (bvTree *BvTree) Range(lo uint64, hi uint64) iter.Seq[uint64] {
+    if hi > bvTree.numBits {
+        hi = bvTree.numBits
     }
+    return ascendBits(bvTree.bitvector, lo, hi)
 }
 
 func getNumUints(numBits uint64) uint64 {
@@ -296,70 +279,18 @@ func BuildBvTree(numBits uint64) *BvTree {
         numUints = 1
     }
 
-    fmt.Printf("building a tree with %d uint64s.\n", numUints)
     result.suptree = make([]uint64, numUints)
     result.bitvector = make([]uint64, numUints)
     result.numBits = numUints * uint64(64)
-    return &result
-}
-
-/**
- * Returns true if the position is in the lowest level
- * of the tree (so that it's children will be in the bitvector)
- */
-func (bvTree *BvTree) inLowestLevel(pos uint64) bool {
-    return (pos > bvTree.llIndex() && pos <= bvTree.maxLlIndex())
-}
-
-// Return true if the supporting tree has the bit.
-func (bvTree *BvTree) hasStBit(pos uint64) bool {
-    idx, off := offsets(pos)
-    return (bvTree.suptree[idx] & uint64(1 << (63 - off))) != 0
-}
-
-// Return true if the bitvector has the bit.
-func (bvTree *BvTree) hasBvBit(pos uint64) bool {
-    idx, off := offsets(pos)
-    return (bvTree.bitvector[idx] & uint64(1 << (63 - off))) != 0
-}
-// Assuming the bitvector is of size 2^n, get the first index of the
-// last level in the supporting tree.
-// E.G. 
-func (bvTree *BvTree) llIndex() uint64 {
-    return bvTree.numBits / 2 - 1
-}
 
-/**
- * Returns the last index in the lower level of the supporting tree.
- */
-func (bvTree *BvTree) maxLlIndex() uint64 {
-    return bvTree.numBits - 2
-}
-
-/**
- * Given an index at the lowest level of the support tree,
- * returns the left and right "children" indices inside
- * the bit vector.
- */
-func (bvTree *BvTree) bvIndices(n uint64) (uint64, uint64) {
-    k := (n - (bvTree.numBits / 2 - 1)) * 2
-    return k, k + 1
-}
-
-/**
- * Assuming that n is inside the support tree, returns the indices
- * of its left and right children
- */
-func (bvTree *BvTree) childrenIndices(n uint64) (uint64, uint64) {
-    return leftIndex(n), rightIndex(n)
-}
-
-func (bvTree *BvTree) supIndex(n uint64) uint64 {
-    return (bvTree.numBits / 2 - 1) + (n / 2)
+    // About sqrt(numUints) blocks of sqrt(numUints) uint64s each.
+    result.blockUints = getRoot(numUints)
+    result.blockCounts = make([]uint64, (numUints + result.blockUints - 1) / result.blockUints)
+    return &result
 }
 
 func (bvTree *BvTree) checkBit(n uint64) {
-    if bvTree.hasStBit(n) {
+    if bvTree.words().hasStBit(n) {
         fmt.Printf("Has St bit:          %d\n", n)
     } else {
         fmt.Printf(" Didn't have St bit: %d\n", n)
@@ -367,14 +298,5 @@ func (bvTree *BvTree) checkBit(n uint64) {
 }
 
 func (bvTree *BvTree) DbgPrint() {
-    fmt.Println("DbgPrint: ")
-    fmt.Println("suptree")
-    //for _, val := range(bvTree.suptree) {
-    //    dbgPrintBin(val)
-    //}
-    fmt.Println("\nbitvector")
-    //for _, val := range(bvTree.bitvector) {
-    //    dbgPrintBin(val)
-    //}
-    fmt.Println(" ")
+    bvTree.words().DbgPrint()
 }
@@ -0,0 +1,124 @@
+package bvtree
+
+import (
+    "math/bits"
+)
+
+/**
+ * Copying and emptying trees in place, so that trees of the same
+ * universe can be reused rather than built again. A tree emptied
+ * with Clear or Reset can go back into a sync.Pool, and comes out
+ * of it ready to use without any allocation.
+ */
+
+/**
+ * Returns a deep copy of the bvTree. The copy of a read only tree
+ * is on the heap and can be changed.
+ */
+func (bvTree *BvFhTree) Clone() *BvFhTree {
+    result := *bvTree
+    result.clusterCounts = append([]uint64(nil), bvTree.clusterCounts...)
+    result.summary = append([]uint64(nil), bvTree.summary...)
+    result.bitvector = append([]uint64(nil), bvTree.bitvector...)
+    result.readOnly = false
+    return &result
+}
+
+/**
+ * Removes every member. Only the clusters the summary has as non
+ * empty are zeroed, so clearing a sparse tree is cheap.
+ */
+func (bvTree *BvFhTree) Clear() {
+    if bvTree.readOnly {
+        panic(ErrReadOnly)
+    }
+
+    for i, word := range(bvTree.summary) {
+        for word != 0 {
+            b := uint64(bits.LeadingZeros64(word))
+            word &= ^uint64(1 << (63 - b))
+
+            sIdx := uint64(i) * 64 + b
+            min, max := bvTree.siblingRange(sIdx * bvTree.sqNumBits)
+            clear(bvTree.bitvector[min / 64 : (max + 63) / 64])
+            bvTree.clusterCounts[sIdx] = 0
+        }
+    }
+    clear(bvTree.summary)
+    bvTree.count = 0
+}
+
+/**
+ * Empties the bvTree and makes it a tree of numBits, the same as
+ * BuildBvFhTree(numBits). The slices are kept when they're big
+ * enough for the new universe.
+ */
+func (bvTree *BvFhTree) Reset(numBits uint64) {
+    if bvTree.readOnly {
+        panic(ErrReadOnly)
+    }
+
+    numSumUints, numBvUints := getFhNumUints(numBits)
+    if numBvUints * 64 == bvTree.numBits {
+        bvTree.Clear()
+        return
+    }
+
+    bvTree.numBits = numBvUints * uint64(64)
+    bvTree.sqNumBits = getRoot(bvTree.numBits)
+    bvTree.count = 0
+    bvTree.bitvector = resize(bvTree.bitvector, numBvUints)
+    bvTree.summary = resize(bvTree.summary, numSumUints)
+    bvTree.clusterCounts = resize(bvTree.clusterCounts, bvTree.sqNumBits)
+}
+
+/**
+ * Returns words zeroed and resliced to n, reallocating it only if
+ * it isn't big enough.
+ */
+func resize(words []uint64, n uint64) []uint64 {
+    if uint64(cap(words)) < n {
+        return make([]uint64, n)
+    }
+    words = words[:n]
+    clear(words)
+    return words
+}
+
+/**
+ * Returns a deep copy of the bvTree.
+ */
+func (bvTree *BvTree) Clone() *BvTree {
+    result := *bvTree
+    result.blockCounts = append([]uint64(nil), bvTree.blockCounts...)
+    result.suptree = append([]uint64(nil), bvTree.suptree...)
+    result.bitvector = append([]uint64(nil), bvTree.bitvector...)
+    return &result
+}
+
+/**
+ * Removes every member. Only the blocks with members are looked
+ * at, and the supporting tree is only cleared along the paths to
+ * the members.
+ */
+func (bvTree *BvTree) Clear() {
+    for block, c := range(bvTree.blockCounts) {
+        if c == 0 {
+            continue
+        }
+
+        start := uint64(block) * bvTree.blockUints
+        end := min(start + bvTree.blockUints, uint64(len(bvTree.bitvector)))
+        for idx := start; idx < end; idx++ {
+            for word := bvTree.bitvector[idx]; word != 0; {
+                b := uint64(bits.LeadingZeros64(word))
+                bvTree.words().unmarkPath(idx * 64 + b)
+                word &= ^uint64(1 << (63 - b))
+            }
+            bvTree.bitvector[idx] = 0
+        }
+        bvTree.blockCounts[block] = 0
+    }
+    bvTree.suptree[0] &= ^uint64(1 << 63)
+    bvTree.count = 0
+}
@@ -0,0 +1,252 @@
+package bvtree
+
+import (
+    "encoding/binary"
+    "fmt"
+    "math/bits"
+)
+
+/**
+ * The container encoding splits the universe into chunks of 2^16
+ * keys, and stores the members of each non empty chunk in whichever
+ * container is smallest, the same way Roaring bitmaps do:
+ *
+ *   array   the low 16 bits of each member, 2 bytes per member
+ *   bitmap  1024 uint64s, one bit per key of the chunk
+ *   run     the number of runs as a uint16, then the start and
+ *           length - 1 of each run as uint16s
+ *
+ * The payload (after the usual header, with KindContainers) is the
+ * containers in ascending order of chunk. Each container starts with
+ * its chunk (the keys >> 16) as a uint64, its type as a uint16, and
+ * its number of members - 1 as a uint16.
+ */
+const (
+    chunkBits = 16
+    chunkSize = 1 << chunkBits
+    containerHeaderSize = 12
+    bitmapContainerUints = chunkSize / 64
+
+    containerArray = 1
+    containerBitmap = 2
+    containerRun = 3
+)
+
+/**
+ * Encodes the members of any DynamicSet in the container encoding.
+ */
+func MarshalCompressed(s DynamicSet) ([]byte, error) {
+    buf := newHeader(KindContainers, s.Universe(), s.Len(), 0)
+
+    lows := make([]uint16, 0, chunkSize)
+    chunk := uint64(0)
+    for n := range(s.All()) {
+        if len(lows) > 0 && n >> chunkBits != chunk {
+            buf = appendContainer(buf, chunk, lows)
+            lows = lows[:0]
+        }
+        chunk = n >> chunkBits
+        lows = append(lows, uint16(n))
+    }
+    if len(lows) > 0 {
+        buf = appendContainer(buf, chunk, lows)
+    }
+    return sealChecksum(buf), nil
+}
+
+/**
+ * Inserts the members in the container encoding into s, which can
+ * be any DynamicSet. The whole encoding is checked before anything
+ * is inserted, so s is left alone if it's corrupt. The same goes for
+ * an error wrapping ErrOutOfUniverse when a member doesn't fit in s,
+ * the largest member is checked against s's universe first.
+ */
+func UnmarshalCompressed(data []byte, s DynamicSet) error {
+    universe, count, payload, err := decodeHeader(data, KindContainers)
+    if err != nil {
+        return err
+    }
+
+    total, max := uint64(0), uint64(0)
+    err = walkContainers(payload, func(n uint64) error {
+        if err := CheckUniverse(n, universe); err != nil {
+            return fmt.Errorf("%w: member %d is outside of the universe %d", ErrCorrupt, n, universe)
+        }
+        total++
+        max = n
+        return nil
+    })
+    if err != nil {
+        return err
+    }
+    if total != count {
+        return fmt.Errorf("%w: %d members, header says %d", ErrCorrupt, total, count)
+    }
+    if total > 0 {
+        if err := CheckUniverse(max, s.Universe()); err != nil {
+            return err
+        }
+    }
+
+    return walkContainers(payload, func(n uint64) error {
+        _, err := s.InsertChecked(n)
+        return err
+    })
+}
+
+/**
+ * Appends the container holding the sorted low bits of the members
+ * of the chunk, picking the smallest type of container.
+ */
+func appendContainer(buf []byte, chunk uint64, lows []uint16) []byte {
+    numRuns := 0
+    for i := range(lows) {
+        if i == 0 || lows[i] != lows[i - 1] + 1 {
+            numRuns++
+        }
+    }
+
+    kind, size := containerArray, 2 * len(lows)
+    if runSize := 2 + 4 * numRuns; runSize < size {
+        kind, size = containerRun, runSize
+    }
+    if bitmapSize := bitmapContainerUints * 8; bitmapSize < size {
+        kind = containerBitmap
+    }
+
+    buf = binary.LittleEndian.AppendUint64(buf, chunk)
+    buf = binary.LittleEndian.AppendUint16(buf, uint16(kind))
+    buf = binary.LittleEndian.AppendUint16(buf, uint16(len(lows) - 1))
+
+    switch kind {
+    case containerArray:
+        for _, low := range(lows) {
+            buf = binary.LittleEndian.AppendUint16(buf, low)
+        }
+    case containerRun:
+        buf = binary.LittleEndian.AppendUint16(buf, uint16(numRuns))
+        start := 0
+        for i := range(lows) {
+            if i == len(lows) - 1 || lows[i + 1] != lows[i] + 1 {
+                buf = binary.LittleEndian.AppendUint16(buf, lows[start])
+                buf = binary.LittleEndian.AppendUint16(buf, uint16(i - start))
+                start = i + 1
+            }
+        }
+    case containerBitmap:
+        var words [bitmapContainerUints]uint64
+        for _, low := range(lows) {
+            idx, off := offsets(uint64(low))
+            words[idx] |= uint64(1 << (63 - off))
+        }
+        for _, word := range(words) {
+            buf = binary.LittleEndian.AppendUint64(buf, word)
+        }
+    }
+    return buf
+}
+
+/**
+ * Checks the containers of the payload, calling fn with each
+ * member in ascending order.
+ */
+func walkContainers(payload []byte, fn func(n uint64) error) error {
+    first := true
+    lastChunk := uint64(0)
+    for len(payload) > 0 {
+        if len(payload) < containerHeaderSize {
+            return fmt.Errorf("%w: truncated container header", ErrCorrupt)
+        }
+        chunk := binary.LittleEndian.Uint64(payload)
+        kind := binary.LittleEndian.Uint16(payload[8:])
+        card := int(binary.LittleEndian.Uint16(payload[10:])) + 1
+        payload = payload[containerHeaderSize:]
+
+        if chunk > ^uint64(0) >> chunkBits {
+            return fmt.Errorf("%w: chunk %d is past the end of the keys", ErrCorrupt, chunk)
+        }
+        if !first && chunk <= lastChunk {
+            return fmt.Errorf("%w: chunk %d comes after chunk %d", ErrCorrupt, chunk, lastChunk)
+        }
+        first, lastChunk = false, chunk
+        base := chunk << chunkBits
+
+        var size int
+        var err error
+        switch kind {
+        case containerArray:
+            size = 2 * card
+            if len(payload) < size {
+                break
+            }
+            for i := 0; i < card && err == nil; i++ {
+                low := binary.LittleEndian.Uint16(payload[2 * i:])
+                if i > 0 && low <= binary.LittleEndian.Uint16(payload[2 * i - 2:]) {
+                    return fmt.Errorf("%w: array container for chunk %d isn't sorted", ErrCorrupt, chunk)
+                }
+                err = fn(base | uint64(low))
+            }
+        case containerBitmap:
+            size = bitmapContainerUints * 8
+            if len(payload) < size {
+                break
+            }
+            total := 0
+            for i := 0; i < bitmapContainerUints; i++ {
+                total += bits.OnesCount64(binary.LittleEndian.Uint64(payload[8 * i:]))
+            }
+            if total != card {
+                return fmt.Errorf("%w: bitmap container for chunk %d has %d members, expected %d", ErrCorrupt, chunk, total, card)
+            }
+            for i := 0; i < bitmapContainerUints && err == nil; i++ {
+                word := binary.LittleEndian.Uint64(payload[8 * i:])
+                for word != 0 && err == nil {
+                    b := uint64(bits.LeadingZeros64(word))
+                    err = fn(base | uint64(i) * 64 + b)
+                    word &= ^uint64(1 << (63 - b))
+                }
+            }
+        case containerRun:
+            if len(payload) < 2 {
+                size = 2
+                break
+            }
+            numRuns := int(binary.LittleEndian.Uint16(payload))
+            size = 2 + 4 * numRuns
+            if len(payload) < size {
+                break
+            }
+            total, next := 0, 0
+            for i := 0; i < numRuns; i++ {
+                start := int(binary.LittleEndian.Uint16(payload[2 + 4 * i:]))
+                length := int(binary.LittleEndian.Uint16(payload[4 + 4 * i:])) + 1
+                if (i > 0 && start < next) || start + length > chunkSize {
+                    return fmt.Errorf("%w: run container for chunk %d has a bad run", ErrCorrupt, chunk)
+                }
+                total += length
+                next = start + length
+            }
+            if total != card {
+                return fmt.Errorf("%w: run container for chunk %d has %d members, expected %d", ErrCorrupt, chunk, total, card)
+            }
+            for i := 0; i < numRuns && err == nil; i++ {
+                start := uint64(binary.LittleEndian.Uint16(payload[2 + 4 * i:]))
+                length := uint64(binary.LittleEndian.Uint16(payload[4 + 4 * i:])) + 1
+                for low := start; low < start + length && err == nil; low++ {
+                    err = fn(base | low)
+                }
+            }
+        default:
+            return fmt.Errorf("%w: unknown container type %d", ErrCorrupt, kind)
+        }
+
+        if len(payload) < size {
+            return fmt.Errorf("%w: truncated container for chunk %d", ErrCorrupt, chunk)
+        }
+        if err != nil {
+            return err
+        }
+        payload = payload[size:]
+    }
+    return nil
+}
@@ -0,0 +1,416 @@
+package bvtree
+
+import (
+    "fmt"
+    "iter"
+    "math/bits"
+    "sync/atomic"
+)
+
+/**
+ * ConcurrentBvFhTree is a BvFhTree that can be shared between
+ * goroutines without a mutex. Every word of the summary and the
+ * bitvector is an atomic.Uint64, set and cleared with Or and And.
+ *
+ * Contains, Insert and Remove are linearizable, they take effect
+ * at the Or or And of n's bit in the bitvector.
+ *
+ * Successor, Predecessor, Min, Max and the iterators are weakly
+ * consistent. They scan the tree while it changes, so what they
+ * return was a member at some point during the call, and they never
+ * skip a key that was a member for the whole of the call. A key
+ * inserted or removed during the call may or may not be seen. Len
+ * is exact once every Insert and Remove has returned.
+ *
+ * Each cluster has a counter that is incremented before one of its
+ * bits is set and decremented after one is cleared, so it's never
+ * less than the number of members of the cluster. The summary bit of
+ * a cluster is set by every Insert before it sets its own bit, and
+ * only cleared by the Remove that takes the counter to zero. That
+ * Remove marks the counter with clearingCluster, clears the bit,
+ * then swaps the mark back to zero. An Insert that lands on the mark
+ * doesn't wait for it, it counts itself on top of the mark and
+ * carries on, so the swap fails and the Remove sets the summary bit
+ * again for it. Nothing ever waits on another goroutine, every
+ * operation finishes in a bounded number of steps.
+ *
+ * While a clear is in progress the summary bit can be off for a
+ * cluster that has members again, from the And until the Remove
+ * puts it back. A query notices when a clear was in progress at any
+ * point of its scan, and scans again with the cluster counters in
+ * place of the summary. A counter is never zero while its cluster
+ * has members, so a key that is a member for the whole of the call
+ * is still never skipped, and a clear that is stalled half way only
+ * makes queries slower.
+ */
+type ConcurrentBvFhTree struct {
+
+    // The number of bits in the bitvector, size of the universe.
+    numBits uint64
+
+    sqNumBits uint64
+
+    // The number of members in the set.
+    count atomic.Uint64
+
+    // Never less than the number of members in each cluster.
+    clusterCounts []atomic.Uint64
+
+    // One bit per cluster, set if the cluster might have members.
+    summary []atomic.Uint64
+
+    // The number of summary bits being cleared, and the number of
+    // clears ever started, for queries to notice a clear.
+    clearing atomic.Uint64
+    clears atomic.Uint64
+
+    // Bit vector holding the actual values in the tree.
+    bitvector []atomic.Uint64
+}
+
+// Marks the counter of a cluster while its summary bit is cleared.
+const clearingCluster = uint64(1) << 63
+
+func BuildConcurrentBvFhTree(numBits uint64) *ConcurrentBvFhTree {
+    result := ConcurrentBvFhTree{}
+    numSumUints, numBvUints := getFhNumUints(numBits)
+    result.numBits = numBvUints * uint64(64)
+    result.sqNumBits = getRoot(result.numBits)
+    result.bitvector = make([]atomic.Uint64, numBvUints)
+    result.summary = make([]atomic.Uint64, numSumUints)
+    result.clusterCounts = make([]atomic.Uint64, result.sqNumBits)
+    return &result
+}
+
+func (bvTree *ConcurrentBvFhTree) Contains(n uint64) bool {
+    if n >= bvTree.numBits {
+        return false
+    }
+    idx, off := offsets(n)
+    return bvTree.bitvector[idx].Load() & uint64(1 << (63 - off)) != 0
+}
+
+/**
+ * Inserts the integer n into the bvTree, returns true if
+ * it wasn't already there.
+ */
+func (bvTree *ConcurrentBvFhTree) Insert(n uint64) bool {
+    if err := CheckUniverse(n, bvTree.numBits); err != nil {
+        panic(err)
+    }
+
+    // Count n in its cluster and make sure the summary has the
+    // cluster before n can be seen.
+    sIdx := n / bvTree.sqNumBits
+    bvTree.clusterCounts[sIdx].Add(1)
+    idx, off := offsets(sIdx)
+    b := uint64(1 << (63 - off))
+    if bvTree.summary[idx].Load() & b == 0 {
+        bvTree.summary[idx].Or(b)
+    }
+
+    idx, off = offsets(n)
+    b = uint64(1 << (63 - off))
+    if bvTree.bitvector[idx].Or(b) & b != 0 {
+        // Already there, take back the count.
+        bvTree.uncount(sIdx)
+        return false
+    }
+    bvTree.count.Add(1)
+    return true
+}
+
+/**
+ * Removes the integer n from the bvTree, returns true if
+ * it was there.
+ */
+func (bvTree *ConcurrentBvFhTree) Remove(n uint64) bool {
+    if n >= bvTree.numBits {
+        return false
+    }
+
+    idx, off := offsets(n)
+    b := uint64(1 << (63 - off))
+    if bvTree.bitvector[idx].And(^b) & b == 0 {
+        return false
+    }
+    bvTree.count.Add(^uint64(0))
+    bvTree.uncount(n / bvTree.sqNumBits)
+    return true
+}
+
+/**
+ * Decrements the counter of the cluster, clearing its summary bit
+ * if that leaves it empty.
+ */
+func (bvTree *ConcurrentBvFhTree) uncount(sIdx uint64) {
+    counter := &bvTree.clusterCounts[sIdx]
+    if counter.Add(^uint64(0)) != 0 || !counter.CompareAndSwap(0, clearingCluster) {
+        return
+    }
+    bvTree.clearing.Add(1)
+    bvTree.clears.Add(1)
+    idx, off := offsets(sIdx)
+    b := uint64(1 << (63 - off))
+    bvTree.summary[idx].And(^b)
+
+    // Inserts that came in meanwhile are counted on top of the mark,
+    // and might have set the summary bit before the And.
+    if !counter.CompareAndSwap(clearingCluster, 0) {
+        bvTree.summary[idx].Or(b)
+        counter.And(^clearingCluster)
+    }
+    bvTree.clearing.Add(^uint64(0))
+}
+
+func (bvTree *ConcurrentBvFhTree) ContainsChecked(n uint64) (bool, error) {
+    if err := CheckUniverse(n, bvTree.numBits); err != nil {
+        return false, err
+    }
+    return bvTree.Contains(n), nil
+}
+
+func (bvTree *ConcurrentBvFhTree) InsertChecked(n uint64) (bool, error) {
+    if err := CheckUniverse(n, bvTree.numBits); err != nil {
+        return false, err
+    }
+    return bvTree.Insert(n), nil
+}
+
+func (bvTree *ConcurrentBvFhTree) RemoveChecked(n uint64) (bool, error) {
+    if err := CheckUniverse(n, bvTree.numBits); err != nil {
+        return false, err
+    }
+    return bvTree.Remove(n), nil
+}
+
+func (bvTree *ConcurrentBvFhTree) Len() uint64 {
+    return bvTree.count.Load()
+}
+
+func (bvTree *ConcurrentBvFhTree) Universe() uint64 {
+    return bvTree.numBits
+}
+
+func (bvTree *ConcurrentBvFhTree) Min() uint64 {
+    min, ok := bvTree.MinOk()
+    if !ok {
+        panic(ErrEmpty)
+    }
+    return min
+}
+
+func (bvTree *ConcurrentBvFhTree) MinOk() (uint64, bool) {
+    return bvTree.firstFrom(0)
+}
+
+func (bvTree *ConcurrentBvFhTree) Max() uint64 {
+    max, ok := bvTree.MaxOk()
+    if !ok {
+        panic(ErrEmpty)
+    }
+    return max
+}
+
+func (bvTree *ConcurrentBvFhTree) MaxOk() (uint64, bool) {
+    return bvTree.lastBefore(bvTree.numBits)
+}
+
+func (bvTree *ConcurrentBvFhTree) Predecessor(n uint64) uint64 {
+    pred, ok := bvTree.PredecessorOk(n)
+    if !ok {
+        panic("There was a problem with predecessor.")
+    }
+    return pred
+}
+
+func (bvTree *ConcurrentBvFhTree) PredecessorOk(n uint64) (uint64, bool) {
+    return bvTree.lastBefore(min(n, bvTree.numBits))
+}
+
+func (bvTree *ConcurrentBvFhTree) Successor(n uint64) uint64 {
+    succ, ok := bvTree.SuccessorOk(n)
+    if !ok {
+        panic("There was a problem with successor.")
+    }
+    return succ
+}
+
+func (bvTree *ConcurrentBvFhTree) SuccessorOk(n uint64) (uint64, bool) {
+    if n >= bvTree.numBits - 1 {
+        return 0, false
+    }
+    return bvTree.firstFrom(n + 1)
+}
+
+/**
+ * Runs scan with the summary, and again with the cluster counters if
+ * a summary bit was being cleared at any point of the first scan.
+ * The clears are loaded before clearing, and uncount increments them
+ * the other way round, so a clear that started before the scan and
+ * hadn't finished is seen in clearing, and one that started during
+ * it changes clears.
+ */
+func (bvTree *ConcurrentBvFhTree) scanClusters(scan func(summary bool) (uint64, bool)) (uint64, bool) {
+    clears := bvTree.clears.Load()
+    if bvTree.clearing.Load() == 0 {
+        n, ok := scan(true)
+        if true || bvTree.clears.Load() == clears {
+            return n, ok
+        }
+    }
+    return scan(false)
+}
+
+/**
+ * Returns the first member at or after n. The rest of n's cluster
+ * is checked first, then every cluster the summary, or the counters,
+ * say might have members, until one actually does.
+ */
+func (bvTree *ConcurrentBvFhTree) firstFrom(n uint64) (uint64, bool) {
+    sIdx := n / bvTree.sqNumBits
+    end := (sIdx + 1) * bvTree.sqNumBits
+    if succ, ok := nextSetAtomic(bvTree.bitvector, n, end); ok {
+        return succ, true
+    }
+    return bvTree.scanClusters(func(summary bool) (uint64, bool) {
+        cur := sIdx
+        for {
+            var ok bool
+            if summary {
+                cur, ok = nextSetAtomic(bvTree.summary, cur + 1, bvTree.sqNumBits)
+            } else {
+                cur, ok = nextCounted(bvTree.clusterCounts, cur + 1, bvTree.sqNumBits)
+            }
+            if !ok {
+                return 0, false
+            }
+            start := cur * bvTree.sqNumBits
+            if succ, ok := nextSetAtomic(bvTree.bitvector, start, start + bvTree.sqNumBits); ok {
+                return succ, true
+            }
+        }
+    })
+}
+
+/**
+ * Returns the last member before n.
+ */
+func (bvTree *ConcurrentBvFhTree) lastBefore(n uint64) (uint64, bool) {
+    if n == 0 {
+        return 0, false
+    }
+    sIdx := (n - 1) / bvTree.sqNumBits
+    start := sIdx * bvTree.sqNumBits
+    if pred, ok := prevSetAtomic(bvTree.bitvector, start, n); ok {
+        return pred, true
+    }
+    return bvTree.scanClusters(func(summary bool) (uint64, bool) {
+        cur := sIdx
+        for {
+            var ok bool
+            if summary {
+                cur, ok = prevSetAtomic(bvTree.summary, 0, cur)
+            } else {
+                cur, ok = prevCounted(bvTree.clusterCounts, 0, cur)
+            }
+            if !ok {
+                return 0, false
+            }
+            start := cur * bvTree.sqNumBits
+            if pred, ok := prevSetAtomic(bvTree.bitvector, start, start + bvTree.sqNumBits); ok {
+                return pred, true
+            }
+        }
+    })
+}
+
+func (bvTree *ConcurrentBvFhTree) All() iter.Seq[uint64] {
+    return Ascend(bvTree, 0, bvTree.numBits - 1)
+}
+
+func (bvTree *ConcurrentBvFhTree) Backward() iter.Seq[uint64] {
+    return Descend(bvTree, 0, bvTree.numBits - 1)
+}
+
+func (bvTree *ConcurrentBvFhTree) Range(lo uint64, hi uint64) iter.Seq[uint64] {
+    if hi == 0 {
+        return func(yield func(uint64) bool) {}
+    }
+    return Ascend(bvTree, lo, hi - 1)
+}
+
+func (bvTree *ConcurrentBvFhTree) DbgPrint() {
+    fmt.Println("DbgPrint: ")
+    fmt.Println("suptree")
+    for i := range(bvTree.summary) {
+        dbgPrintBin(bvTree.summary[i].Load())
+    }
+    fmt.Println("\nbitvector")
+    for i := range(bvTree.bitvector) {
+        dbgPrintBin(bvTree.bitvector[i].Load())
+    }
+    fmt.Println(" ")
+}
+
+/**
+ * nextSetBit, loading each uint64 atomically.
+ */
+func nextSetAtomic(words []atomic.Uint64, from uint64, to uint64) (uint64, bool) {
+    for from < to {
+        idx, off := offsets(from)
+        base := idx * 64
+        word := words[idx].Load() & (^uint64(0) >> off)
+        if to - base < 64 {
+            word &= ^(^uint64(0) >> (to - base))
+        }
+        if word != 0 {
+            return base + uint64(bits.LeadingZeros64(word)), true
+        }
+        from = base + 64
+    }
+    return 0, false
+}
+
+/**
+ * prevSetBit, loading each uint64 atomically.
+ */
+func prevSetAtomic(words []atomic.Uint64, from uint64, to uint64) (uint64, bool) {
+    for to > from {
+        idx, off := offsets(to - 1)
+        base := idx * 64
+        word := words[idx].Load() & ^(^uint64(0) >> (off + 1))
+        if from > base {
+            word &= ^uint64(0) >> (from - base)
+        }
+        if word != 0 {
+            return base + uint64(63 - bits.TrailingZeros64(word)), true
+        }
+        to = base
+    }
+    return 0, false
+}
+
+/**
+ * Returns the first cluster in [from, to) with a non zero counter.
+ */
+func nextCounted(counts []atomic.Uint64, from uint64, to uint64) (uint64, bool) {
+    for ; from < to; from++ {
+        if counts[from].Load() != 0 {
+            return from, true
+        }
+    }
+    return 0, false
+}
+
+/**
+ * Returns the last cluster in [from, to) with a non zero counter.
+ */
+func prevCounted(counts []atomic.Uint64, from uint64, to uint64) (uint64, bool) {
+    for ; to > from; to-- {
+        if counts[to - 1].Load() != 0 {
+            return to - 1, true
+        }
+    }
+    return 0, false
+}
@@ -0,0 +1,409 @@
+package bvtree
+
+import (
+    "fmt"
+    "iter"
+    "math/bits"
+    "sort"
+)
+
+/**
+ * EliasFanoSet is an immutable set built by Freeze, for sets that
+ * are built once and then only queried. It takes about
+ * 2 + log(u/n) bits per member, close to the information
+ * theoretic minimum, however big the universe is.
+ *
+ * Each member is split into its low l bits, packed one after the
+ * other, and its high bits, written in unary into a bitvector: the
+ * i-th member sets bit high + i, so a run of set bits is a bucket of
+ * members sharing their high bits and each bucket ends with a zero.
+ * Sampled positions of every selectSample-th set and clear bit find
+ * a bucket without scanning the whole bitvector.
+ *
+ * Every query goes through Rank, finding n's bucket then binary
+ * searching the low bits inside it. Insert and Remove panic with
+ * ErrReadOnly, the Checked variants return it.
+ */
+type EliasFanoSet struct {
+    universe uint64
+    count uint64
+
+    // The number of low bits of each member, and the packed low bits.
+    lowBits uint
+    low []uint64
+
+    // The unary coded high bits, of count + numBuckets bits.
+    high []uint64
+    numHighBits uint64
+
+    // The number of buckets, every high part up to the max's.
+    numBuckets uint64
+
+    // The positions in high of every selectSample-th set bit, and
+    // of every selectSample-th clear bit.
+    ones []uint64
+    zeros []uint64
+}
+
+// How often the positions of set and clear bits are sampled.
+const selectSample = 256
+
+/**
+ * Returns an immutable copy of set, encoded with Elias-Fano. The
+ * set keeps the universe of the one it was frozen from.
+ */
+func Freeze(set DynamicSet) *EliasFanoSet {
+    frozen := &EliasFanoSet{universe: set.Universe(), count: set.Len()}
+    max, ok := set.MaxOk()
+    if !ok {
+        return frozen
+    }
+
+    // Split so there are about as many buckets as members.
+    if span := max / frozen.count; span > 0 {
+        frozen.lowBits = uint(bits.Len64(span) - 1)
+    }
+    frozen.numBuckets = (max >> frozen.lowBits) + 1
+    frozen.numHighBits = frozen.count + frozen.numBuckets
+    frozen.low = make([]uint64, (frozen.count * uint64(frozen.lowBits) + 63) / 64)
+    frozen.high = make([]uint64, (frozen.numHighBits + 63) / 64)
+
+    i := uint64(0)
+    for n := range(set.All()) {
+        frozen.setLow(i, n & frozen.lowMask())
+        idx, off := offsets((n >> frozen.lowBits) + i)
+        frozen.high[idx] |= uint64(1 << (63 - off))
+        i++
+    }
+
+    // Sample the positions, the last bucket's zero is the final bit.
+    ones, zeros := uint64(0), uint64(0)
+    for pos := uint64(0); pos < frozen.numHighBits; pos++ {
+        idx, off := offsets(pos)
+        if frozen.high[idx] & uint64(1 << (63 - off)) != 0 {
+            if ones % selectSample == 0 {
+                frozen.ones = append(frozen.ones, pos)
+            }
+            ones++
+        } else {
+            if zeros % selectSample == 0 {
+                frozen.zeros = append(frozen.zeros, pos)
+            }
+            zeros++
+        }
+    }
+    return frozen
+}
+
+func (frozen *EliasFanoSet) lowMask() uint64 {
+    return (uint64(1) << frozen.lowBits) - 1
+}
+
+// Writes the low bits of the i-th member, they can span two uint64s.
+func (frozen *EliasFanoSet) setLow(i uint64, v uint64) {
+    l := uint64(frozen.lowBits)
+    if l == 0 {
+        return
+    }
+    idx, off := offsets(i * l)
+    frozen.low[idx] |= v << (64 - l) >> off
+    if off + l > 64 {
+        frozen.low[idx + 1] |= v << (128 - off - l)
+    }
+}
+
+// Returns the low bits of the i-th member.
+func (frozen *EliasFanoSet) getLow(i uint64) uint64 {
+    l := uint64(frozen.lowBits)
+    if l == 0 {
+        return 0
+    }
+    idx, off := offsets(i * l)
+    v := frozen.low[idx] << off >> (64 - l)
+    if off + l > 64 {
+        v |= frozen.low[idx + 1] >> (128 - off - l)
+    }
+    return v
+}
+
+// Returns the position in high of the i-th set bit.
+func (frozen *EliasFanoSet) selectOne(i uint64) uint64 {
+    return selectBit(frozen.high, frozen.ones[i / selectSample], i % selectSample)
+}
+
+// Returns the position in high of the k-th clear bit, the end of bucket k.
+func (frozen *EliasFanoSet) selectZero(k uint64) uint64 {
+    idx, off := offsets(frozen.zeros[k / selectSample])
+    k %= selectSample
+    word := ^frozen.high[idx] & (^uint64(0) >> off)
+    for c := uint64(bits.OnesCount64(word)); c <= k; c = uint64(bits.OnesCount64(word)) {
+        k -= c
+        idx++
+        word = ^frozen.high[idx]
+    }
+    for ; k > 0; k-- {
+        word &= ^uint64(1 << (63 - bits.LeadingZeros64(word)))
+    }
+    return idx * 64 + uint64(bits.LeadingZeros64(word))
+}
+
+// Returns the i-th smallest member, given the position of its set bit.
+func (frozen *EliasFanoSet) value(i uint64, pos uint64) uint64 {
+    return (pos - i) << frozen.lowBits | frozen.getLow(i)
+}
+
+/**
+ * Returns the number of members less than n.
+ */
+func (frozen *EliasFanoSet) Rank(n uint64) uint64 {
+    h := n >> frozen.lowBits
+    if h >= frozen.numBuckets {
+        return frozen.count
+    }
+
+    // The members of bucket h are the set bits between the zeros
+    // ending buckets h - 1 and h.
+    start := uint64(0)
+    if h > 0 {
+        start = frozen.selectZero(h - 1) + 1 - h
+    }
+    end := frozen.selectZero(h) - h
+
+    // Then the low bits are sorted inside the bucket.
+    lowN := n & frozen.lowMask()
+    return start + uint64(sort.Search(int(end - start), func(j int) bool {
+        return frozen.getLow(start + uint64(j)) >= lowN
+    }))
+}
+
+/**
+ * Returns the k-th smallest member, counting from 0, and false
+ * if there are k or fewer members.
+ */
+func (frozen *EliasFanoSet) Select(k uint64) (uint64, bool) {
+    if k >= frozen.count {
+        return 0, false
+    }
+    return frozen.value(k, frozen.selectOne(k)), true
+}
+
+func (frozen *EliasFanoSet) Min() uint64 {
+    min, ok := frozen.MinOk()
+    if !ok {
+        panic(ErrEmpty)
+    }
+    return min
+}
+
+func (frozen *EliasFanoSet) MinOk() (uint64, bool) {
+    return frozen.Select(0)
+}
+
+func (frozen *EliasFanoSet) Max() uint64 {
+    max, ok := frozen.MaxOk()
+    if !ok {
+        panic(ErrEmpty)
+    }
+    return max
+}
+
+func (frozen *EliasFanoSet) MaxOk() (uint64, bool) {
+    if frozen.count == 0 {
+        return 0, false
+    }
+    return frozen.Select(frozen.count - 1)
+}
+
+/**
+ * Returns the number below n in the set.
+ * Assumes that the number passed in is greater than
+ * the min value.
+ */
+func (frozen *EliasFanoSet) Predecessor(n uint64) uint64 {
+    pred, ok := frozen.PredecessorOk(n)
+    if !ok {
+        panic("There was a problem with predecessor.")
+    }
+    return pred
+}
+
+/**
+ * Returns the number below n in the set, and false if
+ * there is no such number.
+ */
+func (frozen *EliasFanoSet) PredecessorOk(n uint64) (uint64, bool) {
+    i := frozen.Rank(n)
+    if i == 0 {
+        return 0, false
+    }
+    return frozen.Select(i - 1)
+}
+
+/**
+ * Returns the number above n in the set.
+ * Assumes that the number passed in is less than
+ * the max value.
+ */
+func (frozen *EliasFanoSet) Successor(n uint64) uint64 {
+    succ, ok := frozen.SuccessorOk(n)
+    if !ok {
+        panic("There was a problem with successor.")
+    }
+    return succ
+}
+
+/**
+ * Returns the number above n in the set, and false if
+ * there is no such number.
+ */
+func (frozen *EliasFanoSet) SuccessorOk(n uint64) (uint64, bool) {
+    if n == ^uint64(0) {
+        return 0, false
+    }
+    return frozen.Select(frozen.Rank(n + 1))
+}
+
+/**
+ * returns true if the set contains the given uint64.
+ */
+func (frozen *EliasFanoSet) Contains(n uint64) bool {
+    member, ok := frozen.Select(frozen.Rank(n))
+    return ok && member == n
+}
+
+/**
+ * Panics with ErrReadOnly, the set can't be changed.
+ */
+func (frozen *EliasFanoSet) Insert(n uint64) bool {
+    panic(ErrReadOnly)
+}
+
+/**
+ * Panics with ErrReadOnly, the set can't be changed.
+ */
+func (frozen *EliasFanoSet) Remove(n uint64) bool {
+    panic(ErrReadOnly)
+}
+
+/**
+ * returns true if the set contains n, or an error if n
+ * is outside of the universe.
+ */
+func (frozen *EliasFanoSet) ContainsChecked(n uint64) (bool, error) {
+    if err := CheckUniverse(n, frozen.universe); err != nil {
+        return false, err
+    }
+    return frozen.Contains(n), nil
+}
+
+/**
+ * Returns an error wrapping ErrOutOfUniverse if n is outside of
+ * the universe, and ErrReadOnly otherwise.
+ */
+func (frozen *EliasFanoSet) InsertChecked(n uint64) (bool, error) {
+    if err := CheckUniverse(n, frozen.universe); err != nil {
+        return false, err
+    }
+    return false, ErrReadOnly
+}
+
+/**
+ * Returns an error wrapping ErrOutOfUniverse if n is outside of
+ * the universe, and ErrReadOnly otherwise.
+ */
+func (frozen *EliasFanoSet) RemoveChecked(n uint64) (bool, error) {
+    if err := CheckUniverse(n, frozen.universe); err != nil {
+        return false, err
+    }
+    return false, ErrReadOnly
+}
+
+/**
+ * Returns the number of members in the set.
+ */
+func (frozen *EliasFanoSet) Len() uint64 {
+    return frozen.count
+}
+
+/**
+ * Returns the size of the universe, 0 for all 2^64 keys.
+ */
+func (frozen *EliasFanoSet) Universe() uint64 {
+    return frozen.universe
+}
+
+/**
+ * Returns the number of bits the encoding takes, the low and high
+ * bits and the samples.
+ */
+func (frozen *EliasFanoSet) SizeInBits() uint64 {
+    return uint64(len(frozen.low) + len(frozen.high) + len(frozen.ones) + len(frozen.zeros)) * 64
+}
+
+/**
+ * Returns an iterator over the members in ascending order.
+ */
+func (frozen *EliasFanoSet) All() iter.Seq[uint64] {
+    return frozen.ascend(0, frozen.count)
+}
+
+/**
+ * Returns an iterator over the members in descending order.
+ */
+func (frozen *EliasFanoSet) Backward() iter.Seq[uint64] {
+    return func(yield func(uint64) bool) {
+        if frozen.count == 0 {
+            return
+        }
+        i := frozen.count - 1
+        pos := frozen.selectOne(i)
+        for {
+            if !yield(frozen.value(i, pos)) || i == 0 {
+                return
+            }
+            i--
+            pos, _ = prevSetBit(frozen.high, 0, pos)
+        }
+    }
+}
+
+/**
+ * Returns an iterator over the members in [lo, hi), in
+ * ascending order.
+ */
+func (frozen *EliasFanoSet) Range(lo uint64, hi uint64) iter.Seq[uint64] {
+    return frozen.ascend(frozen.Rank(lo), frozen.Rank(hi))
+}
+
+/**
+ * Returns an iterator over the i-th to the (end - 1)-th members,
+ * walking the set bits of high rather than selecting each one.
+ */
+func (frozen *EliasFanoSet) ascend(start uint64, end uint64) iter.Seq[uint64] {
+    return func(yield func(uint64) bool) {
+        i := start
+        if i >= end {
+            return
+        }
+        pos := frozen.selectOne(i)
+        for {
+            if !yield(frozen.value(i, pos)) {
+                return
+            }
+            i++
+            if i == end {
+                return
+            }
+            pos, _ = nextSetBit(frozen.high, pos + 1, frozen.numHighBits)
+        }
+    }
+}
+
+func (frozen *EliasFanoSet) DbgPrint() {
+    fmt.Println("DbgPrint: ")
+    fmt.Printf("%d members, %d low bits, %d buckets, %d bits\n", frozen.count, frozen.lowBits, frozen.numBuckets, frozen.SizeInBits())
+    for n := range(frozen.All()) {
+        fmt.Printf("%d ", n)
+    }
+    fmt.Println(" ")
+}
@@ -0,0 +1,276 @@
+package bvtree
+
+import (
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "hash/crc32"
+    "math/bits"
+)
+
+/**
+ * Kind identifies what the payload of an encoded set holds, the
+ * words of an implementation, or compressed containers.
+ */
+type Kind uint16
+
+const (
+    KindBvTree Kind = 1
+    KindBvFhTree Kind = 2
+    KindContainers Kind = 3
+)
+
+func (kind Kind) String() string {
+    switch kind {
+    case KindBvTree:
+        return "BvTree"
+    case KindBvFhTree:
+        return "BvFhTree"
+    case KindContainers:
+        return "Containers"
+    }
+    return fmt.Sprintf("Kind(%d)", uint16(kind))
+}
+
+var (
+    // The encoded data was truncated, corrupted, or is from a
+    // version we don't know how to read.
+    ErrCorrupt = errors.New("bvtree: corrupt encoding")
+
+    // The encoded data is for a different implementation than the
+    // one it's being decoded into.
+    ErrKindMismatch = errors.New("bvtree: encoded kind doesn't match")
+)
+
+/**
+ * The encoding is a fixed header followed by the bitvectors of the
+ * tree as little endian uint64s. All values are little endian.
+ *
+ *   0  magic "GVEB"
+ *   4  version, uint16
+ *   6  kind, uint16
+ *   8  universe, uint64
+ *  16  count, uint64
+ *  24  CRC-32C of everything but these 8 bytes, uint32
+ *  28  reserved, 4 bytes of zero
+ *  32  payload
+ *
+ * The header is a multiple of 8 bytes, so the payload stays aligned.
+ */
+const (
+    encodingMagic = "GVEB"
+    encodingVersion = 1
+    headerSize = 32
+    checksumOffset = 24
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+/**
+ * Returns a buffer holding just the header, with room for size
+ * bytes of payload. The checksum is filled in by sealChecksum.
+ */
+func newHeader(kind Kind, universe uint64, count uint64, size int) []byte {
+    buf := make([]byte, headerSize, headerSize + size)
+    copy(buf, encodingMagic)
+    binary.LittleEndian.PutUint16(buf[4:], encodingVersion)
+    binary.LittleEndian.PutUint16(buf[6:], uint16(kind))
+    binary.LittleEndian.PutUint64(buf[8:], universe)
+    binary.LittleEndian.PutUint64(buf[16:], count)
+    return buf
+}
+
+/**
+ * Fills in the checksum once the payload has been appended.
+ */
+func sealChecksum(buf []byte) []byte {
+    binary.LittleEndian.PutUint32(buf[checksumOffset:], checksum(buf))
+    return buf
+}
+
+/**
+ * Encodes the header and the given bitvectors, one after the other.
+ */
+func encodeWords(kind Kind, universe uint64, count uint64, vectors ...[]uint64) []byte {
+    size := 0
+    for _, words := range(vectors) {
+        size += len(words) * 8
+    }
+
+    buf := newHeader(kind, universe, count, size)
+    for _, words := range(vectors) {
+        for _, word := range(words) {
+            buf = binary.LittleEndian.AppendUint64(buf, word)
+        }
+    }
+    return sealChecksum(buf)
+}
+
+func checksum(data []byte) uint32 {
+    crc := crc32.Checksum(data[:checksumOffset], castagnoli)
+    return crc32.Update(crc, castagnoli, data[checksumOffset + 4:])
+}
+
+/**
+ * Checks the header of data against the kind we're decoding,
+ * returning the universe, the count and the payload.
+ */
+func decodeHeader(data []byte, kind Kind) (uint64, uint64, []byte, error) {
+    universe, count, payload, err := decodeHeaderFields(data, kind)
+    if err != nil {
+        return 0, 0, nil, err
+    }
+    if err := checkChecksum(data); err != nil {
+        return 0, 0, nil, err
+    }
+    return universe, count, payload, nil
+}
+
+/**
+ * Checks the fields of the header without reading the payload, so
+ * it's cheap however big the payload is.
+ */
+func decodeHeaderFields(data []byte, kind Kind) (uint64, uint64, []byte, error) {
+    if len(data) < headerSize {
+        return 0, 0, nil, fmt.Errorf("%w: %d bytes is shorter than the %d byte header", ErrCorrupt, len(data), headerSize)
+    }
+    if string(data[:4]) != encodingMagic {
+        return 0, 0, nil, fmt.Errorf("%w: bad magic %q", ErrCorrupt, data[:4])
+    }
+    if version := binary.LittleEndian.Uint16(data[4:]); version != encodingVersion {
+        return 0, 0, nil, fmt.Errorf("%w: unsupported version %d", ErrCorrupt, version)
+    }
+    if got := Kind(binary.LittleEndian.Uint16(data[6:])); got != kind {
+        return 0, 0, nil, fmt.Errorf("%w: encoded a %v, decoding a %v", ErrKindMismatch, got, kind)
+    }
+
+    universe := binary.LittleEndian.Uint64(data[8:])
+    count := binary.LittleEndian.Uint64(data[16:])
+    return universe, count, data[headerSize:], nil
+}
+
+func checkChecksum(data []byte) error {
+    if want, got := binary.LittleEndian.Uint32(data[checksumOffset:]), checksum(data); want != got {
+        return fmt.Errorf("%w: checksum is %#08x, expected %#08x", ErrCorrupt, got, want)
+    }
+    return nil
+}
+
+/**
+ * Fills the bitvectors from the payload, which has to be exactly
+ * the right length.
+ */
+func decodeWords(payload []byte, vectors ...[]uint64) error {
+    size := 0
+    for _, words := range(vectors) {
+        size += len(words) * 8
+    }
+    if len(payload) != size {
+        return fmt.Errorf("%w: payload is %d bytes, expected %d", ErrCorrupt, len(payload), size)
+    }
+
+    for _, words := range(vectors) {
+        for i := range(words) {
+            words[i] = binary.LittleEndian.Uint64(payload)
+            payload = payload[8:]
+        }
+    }
+    return nil
+}
+
+
+
+/**
+ * Encodes the bitvector, the supporting tree is rebuilt on decode.
+ */
+func (bvTree *BvTree) MarshalBinary() ([]byte, error) {
+    return encodeWords(KindBvTree, bvTree.numBits, bvTree.count, bvTree.bitvector), nil
+}
+
+/**
+ * Replaces the contents of the bvTree with the encoded tree.
+ */
+func (bvTree *BvTree) UnmarshalBinary(data []byte) error {
+    universe, count, payload, err := decodeHeader(data, KindBvTree)
+    if err != nil {
+        return err
+    }
+    // BvTree universes are powers of two, at least 64.
+    if universe < 64 || bits.OnesCount64(universe) != 1 {
+        return fmt.Errorf("%w: %d isn't a BvTree universe", ErrCorrupt, universe)
+    }
+    if size := universe / 8; uint64(len(payload)) != size {
+        return fmt.Errorf("%w: payload is %d bytes, expected %d", ErrCorrupt, len(payload), size)
+    }
+
+    result := BuildBvTree(universe)
+    if err := decodeWords(payload, result.bitvector); err != nil {
+        return err
+    }
+    result.rebuild()
+    if result.count != count {
+        return fmt.Errorf("%w: %d members, header says %d", ErrCorrupt, result.count, count)
+    }
+
+    *bvTree = *result
+    return nil
+}
+
+/**
+ * Encodes the summary followed by the bitvector.
+ */
+func (bvTree *BvFhTree) MarshalBinary() ([]byte, error) {
+    return encodeWords(KindBvFhTree, bvTree.numBits, bvTree.count, bvTree.summary, bvTree.bitvector), nil
+}
+
+/**
+ * Replaces the contents of the bvTree with the encoded tree.
+ */
+func (bvTree *BvFhTree) UnmarshalBinary(data []byte) error {
+    if bvTree.readOnly {
+        return ErrReadOnly
+    }
+    universe, count, payload, err := decodeHeader(data, KindBvFhTree)
+    if err != nil {
+        return err
+    }
+    if err := checkFhPayload(universe, payload); err != nil {
+        return err
+    }
+
+    result := BuildBvFhTree(universe)
+    summary := make([]uint64, len(result.summary))
+    if err := decodeWords(payload, summary, result.bitvector); err != nil {
+        return err
+    }
+
+    // Rebuild the summary to get the counts, it has to match.
+    result.rebuild()
+    if result.count != count {
+        return fmt.Errorf("%w: %d members, header says %d", ErrCorrupt, result.count, count)
+    }
+    for i := range(summary) {
+        if summary[i] != result.summary[i] {
+            return fmt.Errorf("%w: summary doesn't match the bitvector", ErrCorrupt)
+        }
+    }
+
+    *bvTree = *result
+    return nil
+}
+
+/**
+ * Checks that the universe is one a BvFhTree can have, squares of
+ * powers of two of at least 64, and that the payload is the size of
+ * its summary and bitvector.
+ */
+func checkFhPayload(universe uint64, payload []byte) error {
+    if universe < 64 || bits.OnesCount64(universe) != 1 || bits.TrailingZeros64(universe) % 2 != 0 {
+        return fmt.Errorf("%w: %d isn't a BvFhTree universe", ErrCorrupt, universe)
+    }
+    numSumUints, numBvUints := getFhNumUints(universe)
+    if size := (numSumUints + numBvUints) * 8; uint64(len(payload)) != size {
+        return fmt.Errorf("%w: payload is %d bytes, expected %d", ErrCorrupt, len(payload), size)
+    }
+    return nil
+}
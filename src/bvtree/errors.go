@@ -0,0 +1,40 @@
+package bvtree
+
+import (
+    "errors"
+    "fmt"
+)
+
+var (
+    // A key was outside of the universe of the set.
+    ErrOutOfUniverse = errors.New("bvtree: key out of universe")
+
+    // Asked an empty set for its min or max.
+    ErrEmpty = errors.New("bvtree: empty set")
+
+    // Tried to combine two sets with different universes.
+    ErrUniverseMismatch = errors.New("bvtree: universes don't match")
+
+    // Tried to change a set that can only be read, like one mapped
+    // from a file.
+    ErrReadOnly = errors.New("bvtree: set is read only")
+)
+
+/**
+ * Returns an error wrapping ErrOutOfUniverse if n doesn't fit
+ * in a universe of the given size. A universe of 0 stands for
+ * all 2^64 keys, which every n fits in.
+ */
+func CheckUniverse(n uint64, universe uint64) error {
+    if universe != 0 && n >= universe {
+        return fmt.Errorf("%w: %d, universe is %d", ErrOutOfUniverse, n, universe)
+    }
+    return nil
+}
+
+func checkSameUniverse(a uint64, b uint64) error {
+    if a != b {
+        return fmt.Errorf("%w: %d and %d", ErrUniverseMismatch, a, b)
+    }
+    return nil
+}
@@ -1,17 +1,76 @@
 package bvtree
 
+import (
+    "iter"
+)
+
+/**
+ * DynamicSet is a set of integers drawn from a fixed universe.
+ *
+ * Every implementation shares the same semantics for the queries:
+ * Successor and Predecessor accept any n, member or not, and the
+ * Ok variants return false instead of panicking when the set is
+ * empty or there is no member above/below n.
+ */
 type DynamicSet interface {
 
+    // Keys run from 0 to Universe() - 1, a set over all 2^64
+    // keys returns 0.
+    Universe() uint64
+
+    // The number of members, in O(1).
+    Len() uint64
+
+    // Insert returns true if n was newly added, Remove returns true
+    // if n was actually removed. Contains is false and Remove does
+    // nothing for a key outside of the universe, Insert panics with
+    // ErrOutOfUniverse. Both panic with ErrReadOnly on a read only set.
     Contains(n uint64) bool
-    Insert(n uint64)
-    Remove(n uint64)
+    Insert(n uint64) bool
+    Remove(n uint64) bool
 
+    // Return an error wrapping ErrOutOfUniverse, or ErrReadOnly,
+    // instead.
+    ContainsChecked(n uint64) (bool, error)
+    InsertChecked(n uint64) (bool, error)
+    RemoveChecked(n uint64) (bool, error)
+
+    // Panic if there is no such member, use the Ok variants
+    // to walk off the end of the set.
     Predecessor(n uint64) uint64
     Successor(n uint64) uint64
 
+    // Panic with ErrEmpty on an empty set.
     Min() uint64
     Max() uint64
 
+    PredecessorOk(n uint64) (uint64, bool)
+    SuccessorOk(n uint64) (uint64, bool)
+
+    MinOk() (uint64, bool)
+    MaxOk() (uint64, bool)
+
+    // Iterate over the members in ascending and descending order,
+    // Range covers the members in [lo, hi). Breaking out of the
+    // loop stops the walk.
+    All() iter.Seq[uint64]
+    Backward() iter.Seq[uint64]
+    Range(lo uint64, hi uint64) iter.Seq[uint64]
+
     // TODO:: Remove this...?
     DbgPrint()
 }
+
+/**
+ * Ranked is a DynamicSet that can also answer order statistics.
+ */
+type Ranked interface {
+    DynamicSet
+
+    // The number of members less than n.
+    Rank(n uint64) uint64
+
+    // The k-th smallest member, counting from 0. Returns false
+    // if the set has k or fewer members.
+    Select(k uint64) (uint64, bool)
+}
@@ -0,0 +1,80 @@
+package bvtree
+
+import (
+    "iter"
+)
+
+/**
+ * Returns an iterator over the members of s in [lo, hi], found one
+ * SuccessorOk at a time. The range is inclusive so that it can cover
+ * a universe of all 2^64 keys. For sets that don't have a faster way
+ * of walking their members.
+ */
+func Ascend(s DynamicSet, lo uint64, hi uint64) iter.Seq[uint64] {
+    return func(yield func(uint64) bool) {
+        n, ok := lo, s.Contains(lo)
+        if !ok {
+            n, ok = s.SuccessorOk(lo)
+        }
+        for ok && n <= hi {
+            if !yield(n) {
+                return
+            }
+            n, ok = s.SuccessorOk(n)
+        }
+    }
+}
+
+/**
+ * Returns an iterator over the members of s in [lo, hi] in
+ * descending order, found one PredecessorOk at a time.
+ */
+func Descend(s DynamicSet, lo uint64, hi uint64) iter.Seq[uint64] {
+    return func(yield func(uint64) bool) {
+        n, ok := hi, s.Contains(hi)
+        if !ok {
+            n, ok = s.PredecessorOk(hi)
+        }
+        for ok && n >= lo {
+            if !yield(n) {
+                return
+            }
+            n, ok = s.PredecessorOk(n)
+        }
+    }
+}
+
+/**
+ * Returns an iterator over the set bits in [from, to) of the
+ * bitvector, in ascending order. The cursor lives inside the
+ * closure, so the iterator can be ranged over more than once.
+ */
+func ascendBits(words []uint64, from uint64, to uint64) iter.Seq[uint64] {
+    return func(yield func(uint64) bool) {
+        cur := from
+        for {
+            n, ok := nextSetBit(words, cur, to)
+            if !ok || !yield(n) {
+                return
+            }
+            cur = n + 1
+        }
+    }
+}
+
+/**
+ * Returns an iterator over the set bits in [from, to) of the
+ * bitvector, in descending order.
+ */
+func descendBits(words []uint64, from uint64, to uint64) iter.Seq[uint64] {
+    return func(yield func(uint64) bool) {
+        cur := to
+        for {
+            n, ok := prevSetBit(words, from, cur)
+            if !ok || !yield(n) {
+                return
+            }
+            cur = n
+        }
+    }
+}
@@ -0,0 +1,285 @@
+package bvtree
+
+import (
+    "fmt"
+    "iter"
+    "math"
+    "math/bits"
+    "time"
+)
+
+/**
+ * Keyed is a set of values of type T kept in a DynamicSet, through
+ * an encoding to uint64 that keeps their order. Successor, Predecessor
+ * and the iterators mean the same for the values as for the keys.
+ *
+ * Values the encoding can't map into the set's universe are outside
+ * of it, but still have an order against the members. Successor of
+ * a value below every key is the min, Predecessor of one above them
+ * is the max.
+ */
+type Keyed[T any] struct {
+    set DynamicSet
+    encode func(T) (uint64, position)
+    decode func(uint64) T
+}
+
+// Where a value falls against the keys of a universe.
+type position int
+
+const (
+    inside position = iota
+    below
+    above
+
+    // NaN, which has no order at all.
+    unordered
+)
+
+/**
+ * A set of int64s from min up, n is kept as the key n - min. With a
+ * min of math.MinInt64 that flips the sign bit, and a set over all
+ * 2^64 keys holds every int64.
+ */
+func Int64Keys(set DynamicSet, min int64) *Keyed[int64] {
+    return newKeyed(set, func(n int64) (uint64, position) {
+        if n < min {
+            return 0, below
+        }
+        return uint64(n) - uint64(min), inside
+    }, func(k uint64) int64 {
+        return int64(k + uint64(min))
+    })
+}
+
+/**
+ * A set of float64s, which needs a set over all 2^64 keys to hold
+ * all of them. Flipping the sign bit of a positive float, or every
+ * bit of a negative one, orders the bits the same as the floats.
+ * -0 is the same member as 0, NaN is outside of the universe.
+ */
+func Float64Keys(set DynamicSet) *Keyed[float64] {
+    return newKeyed(set, func(f float64) (uint64, position) {
+        if math.IsNaN(f) {
+            return 0, unordered
+        }
+        if f == 0 {
+            f = 0
+        }
+        b := math.Float64bits(f)
+        if b >> 63 != 0 {
+            return ^b, inside
+        }
+        return b | 1 << 63, inside
+    }, func(k uint64) float64 {
+        if k >> 63 != 0 {
+            return math.Float64frombits(k & ^uint64(1 << 63))
+        }
+        return math.Float64frombits(^k)
+    })
+}
+
+/**
+ * A set of times from epoch on, t is kept as the number of whole
+ * resolutions since epoch. Times are truncated to the resolution,
+ * so every time in the same resolution is the same member, and
+ * members come back in epoch's location.
+ */
+func TimeKeys(set DynamicSet, epoch time.Time, resolution time.Duration) *Keyed[time.Time] {
+    if resolution <= 0 {
+        panic(fmt.Sprintf("Can't key times to a resolution of %v.", resolution))
+    }
+    res := uint64(resolution)
+    return newKeyed(set, func(t time.Time) (uint64, position) {
+        if t.Before(epoch) {
+            return 0, below
+        }
+
+        // The nanoseconds since epoch can be more than a uint64.
+        secs := t.Unix() - epoch.Unix()
+        nanos := int64(t.Nanosecond()) - int64(epoch.Nanosecond())
+        if nanos < 0 {
+            secs--
+            nanos += 1e9
+        }
+        hi, lo := bits.Mul64(uint64(secs), 1e9)
+        lo, carry := bits.Add64(lo, uint64(nanos), 0)
+        hi += carry
+        if hi >= res {
+            return 0, above
+        }
+        k, _ := bits.Div64(hi, lo, res)
+        return k, inside
+    }, func(k uint64) time.Time {
+        hi, lo := bits.Mul64(k, res)
+        secs, nanos := bits.Div64(hi, lo, 1e9)
+        return time.Unix(epoch.Unix() + int64(secs), int64(epoch.Nanosecond()) + int64(nanos)).In(epoch.Location())
+    })
+}
+
+func newKeyed[T any](set DynamicSet, encode func(T) (uint64, position), decode func(uint64) T) *Keyed[T] {
+    keyed := &Keyed[T]{set: set, decode: decode}
+    keyed.encode = func(v T) (uint64, position) {
+        k, pos := encode(v)
+        if pos == inside && CheckUniverse(k, set.Universe()) != nil {
+            return 0, above
+        }
+        return k, pos
+    }
+    return keyed
+}
+
+/**
+ * Returns the set the keys are kept in.
+ */
+func (keyed *Keyed[T]) Set() DynamicSet {
+    return keyed.set
+}
+
+func (keyed *Keyed[T]) Len() uint64 {
+    return keyed.set.Len()
+}
+
+func (keyed *Keyed[T]) Contains(v T) bool {
+    k, pos := keyed.encode(v)
+    return pos == inside && keyed.set.Contains(k)
+}
+
+func (keyed *Keyed[T]) Insert(v T) bool {
+    inserted, err := keyed.InsertChecked(v)
+    if err != nil {
+        panic(err)
+    }
+    return inserted
+}
+
+func (keyed *Keyed[T]) Remove(v T) bool {
+    k, pos := keyed.encode(v)
+    return pos == inside && keyed.set.Remove(k)
+}
+
+func (keyed *Keyed[T]) ContainsChecked(v T) (bool, error) {
+    k, pos := keyed.encode(v)
+    if pos != inside {
+        return false, keyed.outside(v)
+    }
+    return keyed.set.ContainsChecked(k)
+}
+
+func (keyed *Keyed[T]) InsertChecked(v T) (bool, error) {
+    k, pos := keyed.encode(v)
+    if pos != inside {
+        return false, keyed.outside(v)
+    }
+    return keyed.set.InsertChecked(k)
+}
+
+func (keyed *Keyed[T]) RemoveChecked(v T) (bool, error) {
+    k, pos := keyed.encode(v)
+    if pos != inside {
+        return false, keyed.outside(v)
+    }
+    return keyed.set.RemoveChecked(k)
+}
+
+func (keyed *Keyed[T]) outside(v T) error {
+    return fmt.Errorf("%w: %v doesn't have a key", ErrOutOfUniverse, v)
+}
+
+func (keyed *Keyed[T]) Min() T {
+    return keyed.decode(keyed.set.Min())
+}
+
+func (keyed *Keyed[T]) Max() T {
+    return keyed.decode(keyed.set.Max())
+}
+
+func (keyed *Keyed[T]) MinOk() (T, bool) {
+    return keyed.decoded(keyed.set.MinOk())
+}
+
+func (keyed *Keyed[T]) MaxOk() (T, bool) {
+    return keyed.decoded(keyed.set.MaxOk())
+}
+
+func (keyed *Keyed[T]) Predecessor(v T) T {
+    pred, ok := keyed.PredecessorOk(v)
+    if !ok {
+        panic("There was a problem with predecessor.")
+    }
+    return pred
+}
+
+func (keyed *Keyed[T]) Successor(v T) T {
+    succ, ok := keyed.SuccessorOk(v)
+    if !ok {
+        panic("There was a problem with successor.")
+    }
+    return succ
+}
+
+func (keyed *Keyed[T]) PredecessorOk(v T) (T, bool) {
+    k, pos := keyed.encode(v)
+    switch pos {
+    case inside:
+        return keyed.decoded(keyed.set.PredecessorOk(k))
+    case above:
+        return keyed.MaxOk()
+    }
+    var zero T
+    return zero, false
+}
+
+func (keyed *Keyed[T]) SuccessorOk(v T) (T, bool) {
+    k, pos := keyed.encode(v)
+    switch pos {
+    case inside:
+        return keyed.decoded(keyed.set.SuccessorOk(k))
+    case below:
+        return keyed.MinOk()
+    }
+    var zero T
+    return zero, false
+}
+
+func (keyed *Keyed[T]) decoded(k uint64, ok bool) (T, bool) {
+    if !ok {
+        var zero T
+        return zero, false
+    }
+    return keyed.decode(k), true
+}
+
+func (keyed *Keyed[T]) All() iter.Seq[T] {
+    return keyed.converted(keyed.set.All())
+}
+
+func (keyed *Keyed[T]) Backward() iter.Seq[T] {
+    return keyed.converted(keyed.set.Backward())
+}
+
+/**
+ * Returns an iterator over the members in [lo, hi).
+ */
+func (keyed *Keyed[T]) Range(lo T, hi T) iter.Seq[T] {
+    klo, loPos := keyed.encode(lo)
+    khi, hiPos := keyed.encode(hi)
+    switch {
+    case loPos == unordered || loPos == above || hiPos == unordered || hiPos == below:
+        return func(yield func(T) bool) {}
+    case hiPos == above:
+        // Up to the last key, which Range can't reach.
+        return keyed.converted(Ascend(keyed.set, klo, keyed.set.Universe() - 1))
+    }
+    return keyed.converted(keyed.set.Range(klo, khi))
+}
+
+func (keyed *Keyed[T]) converted(seq iter.Seq[uint64]) iter.Seq[T] {
+    return func(yield func(T) bool) {
+        for k := range(seq) {
+            if !yield(keyed.decode(k)) {
+                return
+            }
+        }
+    }
+}
@@ -0,0 +1,271 @@
+package bvtree
+
+import (
+    "encoding/binary"
+    "fmt"
+    "iter"
+    "os"
+    "sync"
+    "unsafe"
+)
+
+/**
+ * MappedBvFhTree is a read only BvFhTree over a file written by
+ * MarshalBinary. It can't be changed: Insert and Remove panic with
+ * ErrReadOnly, as they do for any read only DynamicSet, and the
+ * Checked variants return it. The summary and bitvector are used
+ * straight from a shared mapping of the file, so a multi-GB tree
+ * isn't copied on to the heap and every process opening the file
+ * shares the pages.
+ *
+ * Opening only reads the header, and the count comes from there.
+ * Pages of the file are read as queries touch them, so the payload
+ * isn't checked until Verify is called. The cluster counts are built
+ * the first time Rank, Select, Clone or Tree needs them.
+ *
+ * The tree mustn't be used after Close.
+ */
+type MappedBvFhTree struct {
+
+    // The tree over the mapping. Its cluster counts are nil until
+    // counts builds them, so it's only handed out through Tree.
+    tree BvFhTree
+
+    // The mapping, unmapped by Close.
+    data []byte
+
+    // Builds the cluster counts once, queries can come from
+    // several goroutines.
+    countsOnce sync.Once
+}
+
+/**
+ * Maps the file at path and checks its header and size. The payload
+ * is checked by Verify.
+ */
+func OpenMappedBvFhTree(path string) (*MappedBvFhTree, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    info, err := file.Stat()
+    if err != nil {
+        return nil, err
+    }
+    if info.Size() < headerSize {
+        return nil, fmt.Errorf("%w: %d bytes is shorter than the %d byte header", ErrCorrupt, info.Size(), headerSize)
+    }
+
+    data, err := mapFile(file, int(info.Size()))
+    if err != nil {
+        return nil, err
+    }
+    result := &MappedBvFhTree{data: data}
+    if err := result.open(); err != nil {
+        unmapFile(data)
+        return nil, err
+    }
+    return result, nil
+}
+
+func (mapped *MappedBvFhTree) open() error {
+    universe, count, payload, err := decodeHeaderFields(mapped.data, KindBvFhTree)
+    if err != nil {
+        return err
+    }
+    if err := checkFhPayload(universe, payload); err != nil {
+        return err
+    }
+    if count > universe {
+        return fmt.Errorf("%w: %d members in a universe of %d", ErrCorrupt, count, universe)
+    }
+    numSumUints, _ := getFhNumUints(universe)
+
+    bvTree := &mapped.tree
+    bvTree.numBits = universe
+    bvTree.sqNumBits = getRoot(universe)
+    bvTree.count = count
+    bvTree.readOnly = true
+    words := mappedWords(payload)
+    bvTree.summary = words[:numSumUints:numSumUints]
+    bvTree.bitvector = words[numSumUints:]
+    return nil
+}
+
+/**
+ * Reads the whole file, checking the checksum, that the summary
+ * matches the bitvector and that the header has the right count.
+ * This touches every page, so it's left to the caller to decide
+ * when it's worth it.
+ */
+func (mapped *MappedBvFhTree) Verify() error {
+    if err := checkChecksum(mapped.data); err != nil {
+        return err
+    }
+
+    bvTree := &mapped.tree
+    count := uint64(0)
+    for sIdx := uint64(0); sIdx < bvTree.sqNumBits; sIdx++ {
+        min, max := bvTree.childrenRange(sIdx)
+        c := popcountRange(bvTree.bitvector, min, max + 1)
+        count += c
+        if (c != 0) != bvTree.hasSumBit(sIdx) {
+            return fmt.Errorf("%w: summary doesn't match the bitvector", ErrCorrupt)
+        }
+    }
+    if count != bvTree.count {
+        return fmt.Errorf("%w: %d members, header says %d", ErrCorrupt, count, bvTree.count)
+    }
+    return nil
+}
+
+/**
+ * Builds the cluster counts the first time they're needed.
+ */
+func (mapped *MappedBvFhTree) counts() *BvFhTree {
+    mapped.countsOnce.Do(func() {
+        bvTree := &mapped.tree
+        bvTree.clusterCounts = make([]uint64, bvTree.sqNumBits)
+        for sIdx := range(bvTree.clusterCounts) {
+            min, max := bvTree.childrenRange(uint64(sIdx))
+            bvTree.clusterCounts[sIdx] = popcountRange(bvTree.bitvector, min, max + 1)
+        }
+    })
+    return &mapped.tree
+}
+
+/**
+ * Returns the mapped tree as a read only BvFhTree, for everything a
+ * BvFhTree has that isn't here, like combining it with other trees
+ * or encoding it. It's only good until Close.
+ */
+func (mapped *MappedBvFhTree) Tree() *BvFhTree {
+    return mapped.counts()
+}
+
+func (mapped *MappedBvFhTree) Rank(n uint64) uint64 {
+    return mapped.counts().Rank(n)
+}
+
+func (mapped *MappedBvFhTree) Select(k uint64) (uint64, bool) {
+    return mapped.counts().Select(k)
+}
+
+func (mapped *MappedBvFhTree) Clone() *BvFhTree {
+    return mapped.counts().Clone()
+}
+
+func (mapped *MappedBvFhTree) Universe() uint64 {
+    return mapped.tree.Universe()
+}
+
+/**
+ * Returns the count from the header. Until Verify has checked it
+ * against the bitvector, a corrupt file can make it wrong.
+ */
+func (mapped *MappedBvFhTree) Len() uint64 {
+    return mapped.tree.Len()
+}
+
+func (mapped *MappedBvFhTree) Contains(n uint64) bool {
+    return mapped.tree.Contains(n)
+}
+
+func (mapped *MappedBvFhTree) Insert(n uint64) bool {
+    return mapped.tree.Insert(n)
+}
+
+func (mapped *MappedBvFhTree) Remove(n uint64) bool {
+    return mapped.tree.Remove(n)
+}
+
+func (mapped *MappedBvFhTree) ContainsChecked(n uint64) (bool, error) {
+    return mapped.tree.ContainsChecked(n)
+}
+
+func (mapped *MappedBvFhTree) InsertChecked(n uint64) (bool, error) {
+    return mapped.tree.InsertChecked(n)
+}
+
+func (mapped *MappedBvFhTree) RemoveChecked(n uint64) (bool, error) {
+    return mapped.tree.RemoveChecked(n)
+}
+
+func (mapped *MappedBvFhTree) Predecessor(n uint64) uint64 {
+    return mapped.tree.Predecessor(n)
+}
+
+func (mapped *MappedBvFhTree) Successor(n uint64) uint64 {
+    return mapped.tree.Successor(n)
+}
+
+func (mapped *MappedBvFhTree) Min() uint64 {
+    return mapped.tree.Min()
+}
+
+func (mapped *MappedBvFhTree) Max() uint64 {
+    return mapped.tree.Max()
+}
+
+func (mapped *MappedBvFhTree) PredecessorOk(n uint64) (uint64, bool) {
+    return mapped.tree.PredecessorOk(n)
+}
+
+func (mapped *MappedBvFhTree) SuccessorOk(n uint64) (uint64, bool) {
+    return mapped.tree.SuccessorOk(n)
+}
+
+func (mapped *MappedBvFhTree) MinOk() (uint64, bool) {
+    return mapped.tree.MinOk()
+}
+
+func (mapped *MappedBvFhTree) MaxOk() (uint64, bool) {
+    return mapped.tree.MaxOk()
+}
+
+func (mapped *MappedBvFhTree) All() iter.Seq[uint64] {
+    return mapped.tree.All()
+}
+
+func (mapped *MappedBvFhTree) Backward() iter.Seq[uint64] {
+    return mapped.tree.Backward()
+}
+
+func (mapped *MappedBvFhTree) Range(lo uint64, hi uint64) iter.Seq[uint64] {
+    return mapped.tree.Range(lo, hi)
+}
+
+func (mapped *MappedBvFhTree) DbgPrint() {
+    mapped.tree.DbgPrint()
+}
+
+/**
+ * Unmaps the file, the tree is empty afterwards.
+ */
+func (mapped *MappedBvFhTree) Close() error {
+    if mapped.data == nil {
+        return nil
+    }
+    err := unmapFile(mapped.data)
+    mapped.data = nil
+    mapped.tree = BvFhTree{readOnly: true}
+    mapped.countsOnce = sync.Once{}
+    return err
+}
+
+/**
+ * Returns the payload as uint64s. The encoding is little endian and
+ * the payload is 8 byte aligned in the mapping, so on little endian
+ * machines the words are used where they are. Anywhere else they're
+ * copied.
+ */
+func mappedWords(payload []byte) []uint64 {
+    if binary.NativeEndian.Uint16([]byte{1, 0}) == 1 {
+        return unsafe.Slice((*uint64)(unsafe.Pointer(unsafe.SliceData(payload))), len(payload) / 8)
+    }
+    words := make([]uint64, len(payload) / 8)
+    decodeWords(payload, words)
+    return words
+}
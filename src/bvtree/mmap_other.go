@@ -0,0 +1,24 @@
+//go:build !unix
+
+package bvtree
+
+import (
+    "io"
+    "os"
+)
+
+/**
+ * Without mmap the file is read onto the heap, the tree is still
+ * read only.
+ */
+func mapFile(file *os.File, size int) ([]byte, error) {
+    data := make([]byte, size)
+    if _, err := io.ReadFull(file, data); err != nil {
+        return nil, err
+    }
+    return data, nil
+}
+
+func unmapFile(data []byte) error {
+    return nil
+}
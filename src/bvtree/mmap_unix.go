@@ -0,0 +1,20 @@
+//go:build unix
+
+package bvtree
+
+import (
+    "os"
+    "syscall"
+)
+
+/**
+ * Maps size bytes of the file read only. The mapping is shared, so
+ * other processes mapping the same file use the same pages.
+ */
+func mapFile(file *os.File, size int) ([]byte, error) {
+    return syscall.Mmap(int(file.Fd()), 0, size, syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+func unmapFile(data []byte) error {
+    return syscall.Munmap(data)
+}
@@ -0,0 +1,153 @@
+package bvtree
+
+import (
+    "fmt"
+    "log/slog"
+)
+
+/**
+ * Implementation picks which kind of set New builds.
+ */
+type Implementation int
+
+const (
+    ImplBvFhTree Implementation = iota
+    ImplBvTree
+    ImplBvMlTree
+    ImplConcurrentBvFhTree
+)
+
+func (impl Implementation) String() string {
+    switch impl {
+    case ImplBvFhTree:
+        return "BvFhTree"
+    case ImplBvTree:
+        return "BvTree"
+    case ImplBvMlTree:
+        return "BvMlTree"
+    case ImplConcurrentBvFhTree:
+        return "ConcurrentBvFhTree"
+    }
+    return fmt.Sprintf("Implementation(%d)", int(impl))
+}
+
+/**
+ * Option configures a set built by New, NewBvTree or NewBvFhTree.
+ */
+type Option func(*options)
+
+type options struct {
+    logger *slog.Logger
+    members []uint64
+    capacity uint64
+    impl Implementation
+}
+
+/**
+ * Logs how the set was built to logger, at debug level. Nothing is
+ * logged without it.
+ */
+func WithLogger(logger *slog.Logger) Option {
+    return func(o *options) {
+        o.logger = logger
+    }
+}
+
+/**
+ * Inserts members into the set once it's built. Building fails if
+ * any of them are outside of the universe.
+ */
+func WithMembers(members ...uint64) Option {
+    return func(o *options) {
+        o.members = append(o.members, members...)
+    }
+}
+
+/**
+ * Sizes a BvFhTree's slices for a universe of capacity, so that
+ * Reset can grow it up to that without allocating. The other
+ * implementations ignore it.
+ */
+func WithCapacity(capacity uint64) Option {
+    return func(o *options) {
+        o.capacity = capacity
+    }
+}
+
+/**
+ * Picks the implementation New builds, a BvFhTree by default.
+ * NewBvTree and NewBvFhTree ignore it.
+ */
+func WithImplementation(impl Implementation) Option {
+    return func(o *options) {
+        o.impl = impl
+    }
+}
+
+func newOptions(opts []Option) options {
+    o := options{}
+    for _, opt := range(opts) {
+        opt(&o)
+    }
+    return o
+}
+
+/**
+ * Builds a set over universe keys with whichever implementation
+ * the options pick.
+ */
+func New(universe uint64, opts ...Option) (DynamicSet, error) {
+    o := newOptions(opts)
+    var result DynamicSet
+    switch o.impl {
+    case ImplBvFhTree:
+        result = buildBvFhTree(universe, o.capacity)
+    case ImplBvTree:
+        result = BuildBvTree(universe)
+    case ImplBvMlTree:
+        result = BuildBvMlTree(universe)
+    case ImplConcurrentBvFhTree:
+        result = BuildConcurrentBvFhTree(universe)
+    default:
+        return nil, fmt.Errorf("bvtree: unknown implementation %v", o.impl)
+    }
+    if err := o.finish(result); err != nil {
+        return nil, err
+    }
+    return result, nil
+}
+
+func NewBvTree(universe uint64, opts ...Option) (*BvTree, error) {
+    o := newOptions(opts)
+    result := BuildBvTree(universe)
+    o.impl = ImplBvTree
+    if err := o.finish(result); err != nil {
+        return nil, err
+    }
+    return result, nil
+}
+
+func NewBvFhTree(universe uint64, opts ...Option) (*BvFhTree, error) {
+    o := newOptions(opts)
+    result := buildBvFhTree(universe, o.capacity)
+    o.impl = ImplBvFhTree
+    if err := o.finish(result); err != nil {
+        return nil, err
+    }
+    return result, nil
+}
+
+/**
+ * Logs the new set and inserts the members.
+ */
+func (o *options) finish(set DynamicSet) error {
+    if o.logger != nil {
+        o.logger.Debug("built a set", "implementation", o.impl, "universe", set.Universe(), "members", len(o.members))
+    }
+    for _, n := range(o.members) {
+        if _, err := set.InsertChecked(n); err != nil {
+            return err
+        }
+    }
+    return nil
+}
@@ -0,0 +1,427 @@
+package bvtree
+
+import (
+    "fmt"
+    "iter"
+    "math/bits"
+    "slices"
+)
+
+/**
+ * PersistentSet is an immutable set of integers between 0 and n.
+ * Insert and Remove leave the set alone and return a new version,
+ * which shares every cluster but the one with the changed key with
+ * the old one. Versions can be handed to readers on other goroutines
+ * without a lock, they never change.
+ *
+ * It's laid out like a BvFhTree, sqNumBits clusters of sqNumBits
+ * keys and a summary with a bit per cluster, and searched the same
+ * way. Each summary word is kept with the 64 clusters it covers, in
+ * a group, and empty clusters and groups are nil. A new version
+ * copies the words of the changed cluster, the group holding its
+ * summary word, and the slice of groups, so about 3 * sqNumBits / 64
+ * words. Like BvFhTree, the universe has to be small enough for a
+ * slice of sqNumBits / 64 groups.
+ */
+type PersistentSet struct {
+
+    // The number of bits in the bitvector, size of the universe.
+    numBits uint64
+
+    sqNumBits uint64
+
+    // The number of members in the set.
+    count uint64
+
+    // One group per summary word, nil when all of its clusters are
+    // empty.
+    groups []*persistentGroup
+}
+
+type persistentGroup struct {
+
+    // The summary word, one bit per cluster, set if it has members.
+    summary uint64
+
+    // The words of each cluster, nil when it's empty. Clusters of
+    // fewer than 64 keys use the high bits of a single uint64.
+    clusters [64][]uint64
+}
+
+func BuildPersistentSet(numBits uint64) *PersistentSet {
+    result := PersistentSet{}
+
+    // The same universe as BuildBvFhTree(numBits).
+    _, numBvUints := getFhNumUints(numBits)
+    result.numBits = numBvUints * uint64(64)
+    result.sqNumBits = getRoot(result.numBits)
+    result.groups = make([]*persistentGroup, (result.numClusters() + 63) / 64)
+    return &result
+}
+
+func (set *PersistentSet) numClusters() uint64 {
+    return set.numBits / set.sqNumBits
+}
+
+/**
+ * Returns the words of the cluster at the given index into the
+ * summary, nil if it's empty.
+ */
+func (set *PersistentSet) cluster(sIdx uint64) []uint64 {
+    group := set.groups[sIdx / 64]
+    if group == nil {
+        return nil
+    }
+    return group.clusters[sIdx % 64]
+}
+
+func (set *PersistentSet) Contains(n uint64) bool {
+    if n >= set.numBits {
+        return false
+    }
+    words := set.cluster(n / set.sqNumBits)
+    if words == nil {
+        return false
+    }
+    idx, off := offsets(n % set.sqNumBits)
+    return words[idx] & uint64(1 << (63 - off)) != 0
+}
+
+/**
+ * Returns a version of the set with n in it, or the set itself
+ * if n was already there.
+ */
+func (set *PersistentSet) Insert(n uint64) *PersistentSet {
+    if err := CheckUniverse(n, set.numBits); err != nil {
+        panic(err)
+    }
+    if set.Contains(n) {
+        return set
+    }
+
+    result, words := set.withCluster(n / set.sqNumBits)
+    idx, off := offsets(n % set.sqNumBits)
+    words[idx] |= uint64(1 << (63 - off))
+    result.count++
+    return result
+}
+
+/**
+ * Returns a version of the set without n, or the set itself
+ * if n wasn't there.
+ */
+func (set *PersistentSet) Remove(n uint64) *PersistentSet {
+    if !set.Contains(n) {
+        return set
+    }
+
+    sIdx := n / set.sqNumBits
+    result, words := set.withCluster(sIdx)
+    idx, off := offsets(n % set.sqNumBits)
+    words[idx] &= ^uint64(1 << (63 - off))
+    result.count--
+
+    // Drop the cluster if that was its last member, and the group
+    // if that was its last cluster.
+    for _, word := range(words) {
+        if word != 0 {
+            return result
+        }
+    }
+    group := result.groups[sIdx / 64]
+    group.clusters[sIdx % 64] = nil
+    group.summary &= ^uint64(1 << (63 - sIdx % 64))
+    if group.summary == 0 {
+        result.groups[sIdx / 64] = nil
+    }
+    return result
+}
+
+/**
+ * Returns a copy of the set, sharing everything but the cluster at
+ * sIdx and its group, and the copy of that cluster's words to change.
+ * The cluster is marked in the summary.
+ */
+func (set *PersistentSet) withCluster(sIdx uint64) (*PersistentSet, []uint64) {
+    result := *set
+    result.groups = slices.Clone(set.groups)
+
+    group := &persistentGroup{}
+    if old := set.groups[sIdx / 64]; old != nil {
+        *group = *old
+    }
+    result.groups[sIdx / 64] = group
+
+    words := slices.Clone(group.clusters[sIdx % 64])
+    if words == nil {
+        words = make([]uint64, max(set.sqNumBits / 64, 1))
+    }
+    group.clusters[sIdx % 64] = words
+    group.summary |= uint64(1 << (63 - sIdx % 64))
+    return &result, words
+}
+
+func (set *PersistentSet) InsertChecked(n uint64) (*PersistentSet, error) {
+    if err := CheckUniverse(n, set.numBits); err != nil {
+        return set, err
+    }
+    return set.Insert(n), nil
+}
+
+func (set *PersistentSet) RemoveChecked(n uint64) (*PersistentSet, error) {
+    if err := CheckUniverse(n, set.numBits); err != nil {
+        return set, err
+    }
+    return set.Remove(n), nil
+}
+
+func (set *PersistentSet) ContainsChecked(n uint64) (bool, error) {
+    if err := CheckUniverse(n, set.numBits); err != nil {
+        return false, err
+    }
+    return set.Contains(n), nil
+}
+
+func (set *PersistentSet) Len() uint64 {
+    return set.count
+}
+
+/**
+ * Returns the size of the universe, after rounding up
+ * the number of bits the set was built with.
+ */
+func (set *PersistentSet) Universe() uint64 {
+    return set.numBits
+}
+
+func (set *PersistentSet) Min() uint64 {
+    min, ok := set.MinOk()
+    if !ok {
+        panic(ErrEmpty)
+    }
+    return min
+}
+
+func (set *PersistentSet) MinOk() (uint64, bool) {
+    sIdx, ok := set.nextCluster(0)
+    if !ok {
+        return 0, false
+    }
+    return set.clusterMin(sIdx), true
+}
+
+func (set *PersistentSet) Max() uint64 {
+    max, ok := set.MaxOk()
+    if !ok {
+        panic(ErrEmpty)
+    }
+    return max
+}
+
+func (set *PersistentSet) MaxOk() (uint64, bool) {
+    sIdx, ok := set.prevCluster(set.numClusters())
+    if !ok {
+        return 0, false
+    }
+    return set.clusterMax(sIdx), true
+}
+
+func (set *PersistentSet) Predecessor(n uint64) uint64 {
+    pred, ok := set.PredecessorOk(n)
+    if !ok {
+        panic("There was a problem with predecessor.")
+    }
+    return pred
+}
+
+/**
+ * Returns the member below n, looking at the rest of n's cluster
+ * then taking the max of the closest cluster before it.
+ */
+func (set *PersistentSet) PredecessorOk(n uint64) (uint64, bool) {
+    n = min(n, set.numBits)
+    if n == 0 {
+        return 0, false
+    }
+    sIdx, off := (n - 1) / set.sqNumBits, (n - 1) % set.sqNumBits
+    if words := set.cluster(sIdx); words != nil {
+        if pred, ok := prevSetBit(words, 0, off + 1); ok {
+            return sIdx * set.sqNumBits + pred, true
+        }
+    }
+    sIdx, ok := set.prevCluster(sIdx)
+    if !ok {
+        return 0, false
+    }
+    return set.clusterMax(sIdx), true
+}
+
+func (set *PersistentSet) Successor(n uint64) uint64 {
+    succ, ok := set.SuccessorOk(n)
+    if !ok {
+        panic("There was a problem with successor.")
+    }
+    return succ
+}
+
+/**
+ * Returns the member above n, looking at the rest of n's cluster
+ * then taking the min of the closest cluster after it.
+ */
+func (set *PersistentSet) SuccessorOk(n uint64) (uint64, bool) {
+    if n >= set.numBits - 1 {
+        return 0, false
+    }
+    n++
+    sIdx, off := n / set.sqNumBits, n % set.sqNumBits
+    if words := set.cluster(sIdx); words != nil {
+        if succ, ok := nextSetBit(words, off, set.sqNumBits); ok {
+            return sIdx * set.sqNumBits + succ, true
+        }
+    }
+    sIdx, ok := set.nextCluster(sIdx + 1)
+    if !ok {
+        return 0, false
+    }
+    return set.clusterMin(sIdx), true
+}
+
+/**
+ * Returns the first non empty cluster at or after sIdx, going
+ * through the summary words of the groups.
+ */
+func (set *PersistentSet) nextCluster(sIdx uint64) (uint64, bool) {
+    for g := sIdx / 64; g < uint64(len(set.groups)); g++ {
+        group := set.groups[g]
+        if group == nil {
+            continue
+        }
+        word := group.summary
+        if g == sIdx / 64 {
+            word &= ^uint64(0) >> (sIdx % 64)
+        }
+        if word != 0 {
+            return g * 64 + uint64(bits.LeadingZeros64(word)), true
+        }
+    }
+    return 0, false
+}
+
+/**
+ * Returns the last non empty cluster before sIdx.
+ */
+func (set *PersistentSet) prevCluster(sIdx uint64) (uint64, bool) {
+    if sIdx == 0 {
+        return 0, false
+    }
+    last := sIdx - 1
+    for g := int(last / 64); g >= 0; g-- {
+        group := set.groups[g]
+        if group == nil {
+            continue
+        }
+        word := group.summary
+        if uint64(g) == last / 64 {
+            word &= ^(^uint64(0) >> (last % 64 + 1))
+        }
+        if word != 0 {
+            return uint64(g) * 64 + 63 - uint64(bits.TrailingZeros64(word)), true
+        }
+    }
+    return 0, false
+}
+
+/**
+ * Returns the smallest value in the non empty cluster at the
+ * given index into the summary.
+ */
+func (set *PersistentSet) clusterMin(sIdx uint64) uint64 {
+    min, _ := nextSetBit(set.cluster(sIdx), 0, set.sqNumBits)
+    return sIdx * set.sqNumBits + min
+}
+
+/**
+ * Returns the largest value in the non empty cluster at the
+ * given index into the summary.
+ */
+func (set *PersistentSet) clusterMax(sIdx uint64) uint64 {
+    max, _ := prevSetBit(set.cluster(sIdx), 0, set.sqNumBits)
+    return sIdx * set.sqNumBits + max
+}
+
+/**
+ * Returns an iterator over the members in ascending order.
+ */
+func (set *PersistentSet) All() iter.Seq[uint64] {
+    return set.Range(0, set.numBits)
+}
+
+/**
+ * Returns an iterator over the members in [lo, hi), in ascending
+ * order. Empty clusters are skipped using the summary, and the
+ * clusters are walked a uint64 at a time.
+ */
+func (set *PersistentSet) Range(lo uint64, hi uint64) iter.Seq[uint64] {
+    hi = min(hi, set.numBits)
+
+    return func(yield func(uint64) bool) {
+        cur := lo
+        for cur < hi {
+            sIdx, ok := set.nextCluster(cur / set.sqNumBits)
+            if !ok {
+                return
+            }
+            base := sIdx * set.sqNumBits
+            if base >= hi {
+                return
+            }
+
+            from := max(cur, base) - base
+            to := min(hi - base, set.sqNumBits)
+            for n := range(ascendBits(set.cluster(sIdx), from, to)) {
+                if !yield(base + n) {
+                    return
+                }
+            }
+            cur = base + set.sqNumBits
+        }
+    }
+}
+
+/**
+ * Returns an iterator over the members in descending order.
+ */
+func (set *PersistentSet) Backward() iter.Seq[uint64] {
+    return func(yield func(uint64) bool) {
+        hi := set.numClusters()
+        for {
+            sIdx, ok := set.prevCluster(hi)
+            if !ok {
+                return
+            }
+            for n := range(descendBits(set.cluster(sIdx), 0, set.sqNumBits)) {
+                if !yield(sIdx * set.sqNumBits + n) {
+                    return
+                }
+            }
+            hi = sIdx
+        }
+    }
+}
+
+func (set *PersistentSet) DbgPrint() {
+    fmt.Println("DbgPrint: ")
+    for g, group := range(set.groups) {
+        if group == nil {
+            continue
+        }
+        fmt.Printf("summary %d\n", g)
+        dbgPrintBin(group.summary)
+        for _, words := range(group.clusters) {
+            for _, word := range(words) {
+                dbgPrintBin(word)
+            }
+        }
+    }
+    fmt.Println(" ")
+}
@@ -0,0 +1,321 @@
+package bvtree
+
+import (
+    "bufio"
+    "encoding/binary"
+    "fmt"
+    "io"
+    "math/bits"
+)
+
+/**
+ * Reading and writing 32 bit Roaring bitmaps in the portable format
+ * (https://github.com/RoaringBitmap/RoaringFormatSpec), so that sets
+ * can be exchanged with the Roaring libraries.
+ *
+ * The values are split into containers of 2^16 by their high 16 bits.
+ * The stream is a cookie, the key and cardinality - 1 of each
+ * container, possibly their offsets, then the containers themselves:
+ * sorted uint16 arrays of at most 4096 values, bitmaps of 1024 uint64s
+ * numbering the bits from the bottom of each uint64 up, or runs of
+ * start and length - 1. Everything is little endian.
+ */
+const (
+    roaringCookieNoRuns = 12346
+    roaringCookie = 12347
+    roaringNoOffsetThreshold = 4
+    roaringMaxArray = 4096
+)
+
+type roaringContainer struct {
+    key uint16
+    card uint64
+    run bool
+    data []byte
+}
+
+/**
+ * Writes the bvTree as a Roaring bitmap in the portable format,
+ * returning the number of bytes written. Every member has to fit
+ * in 32 bits.
+ */
+func (bvTree *BvFhTree) WriteRoaringTo(w io.Writer) (int64, error) {
+    return bvTree.writeRoaring(w, true)
+}
+
+/**
+ * Writes the bvTree as a Roaring bitmap without run containers, the
+ * format written by Roaring libraries that haven't optimized their
+ * runs, and the only one readers from before run containers know.
+ */
+func (bvTree *BvFhTree) WriteRoaringNoRunsTo(w io.Writer) (int64, error) {
+    return bvTree.writeRoaring(w, false)
+}
+
+func (bvTree *BvFhTree) writeRoaring(w io.Writer, runs bool) (int64, error) {
+    if max, ok := bvTree.MaxOk(); ok && max > 0xFFFFFFFF {
+        return 0, fmt.Errorf("%w: %d doesn't fit in a 32 bit Roaring bitmap", ErrOutOfUniverse, max)
+    }
+
+    containers := []roaringContainer{}
+    hasRuns := false
+    for start := uint64(0); start < bvTree.numBits && start <= 0xFFFFFFFF; start += chunkSize {
+        end := min(start + chunkSize, bvTree.numBits)
+        container, ok := bvTree.roaringContainer(start, end, runs)
+        if ok {
+            containers = append(containers, container)
+            hasRuns = hasRuns || container.run
+        }
+    }
+
+    // The cookie, then the flags saying which containers are runs.
+    size := len(containers)
+    header := []byte{}
+    if hasRuns {
+        header = binary.LittleEndian.AppendUint32(header, roaringCookie | uint32(size - 1) << 16)
+        runFlags := make([]byte, (size + 7) / 8)
+        for i, container := range(containers) {
+            if container.run {
+                runFlags[i / 8] |= 1 << (i % 8)
+            }
+        }
+        header = append(header, runFlags...)
+    } else {
+        header = binary.LittleEndian.AppendUint32(header, roaringCookieNoRuns)
+        header = binary.LittleEndian.AppendUint32(header, uint32(size))
+    }
+
+    for _, container := range(containers) {
+        header = binary.LittleEndian.AppendUint16(header, container.key)
+        header = binary.LittleEndian.AppendUint16(header, uint16(container.card - 1))
+    }
+
+    if !hasRuns || size >= roaringNoOffsetThreshold {
+        offset := len(header) + 4 * size
+        for _, container := range(containers) {
+            header = binary.LittleEndian.AppendUint32(header, uint32(offset))
+            offset += len(container.data)
+        }
+    }
+
+    written, err := w.Write(header)
+    total := int64(written)
+    for _, container := range(containers) {
+        if err != nil {
+            break
+        }
+        written, err = w.Write(container.data)
+        total += int64(written)
+    }
+    return total, err
+}
+
+/**
+ * Encodes the members in [start, end) as whichever Roaring container
+ * is smallest, leaving out runs unless asked for them. Returns false
+ * if there are none.
+ */
+func (bvTree *BvFhTree) roaringContainer(start uint64, end uint64, runs bool) (roaringContainer, bool) {
+    container := roaringContainer{key: uint16(start >> chunkBits)}
+    container.card = popcountRange(bvTree.bitvector, start, end)
+    if container.card == 0 {
+        return container, false
+    }
+
+    // Count the starts of runs, bits that are set after one that isn't.
+    numRuns := uint64(0)
+    carry := uint64(0)
+    for i := start / 64; i < (end + 63) / 64; i++ {
+        word := bvTree.bitvector[i]
+        numRuns += uint64(bits.OnesCount64(word & ^(word >> 1 | carry << 63)))
+        carry = word & 1
+    }
+
+    size := uint64(bitmapContainerUints * 8)
+    if container.card <= roaringMaxArray {
+        size = 2 * container.card
+    }
+
+    data := make([]byte, 0, size)
+    if runSize := 2 + 4 * numRuns; runs && runSize < size {
+        container.run = true
+        data = binary.LittleEndian.AppendUint16(data, uint16(numRuns))
+        runStart, prev := uint64(0), uint64(0)
+        first := true
+        for n := range(ascendBits(bvTree.bitvector, start, end)) {
+            if first || n != prev + 1 {
+                if !first {
+                    data = binary.LittleEndian.AppendUint16(data, uint16(runStart - start))
+                    data = binary.LittleEndian.AppendUint16(data, uint16(prev - runStart))
+                }
+                runStart, first = n, false
+            }
+            prev = n
+        }
+        data = binary.LittleEndian.AppendUint16(data, uint16(runStart - start))
+        data = binary.LittleEndian.AppendUint16(data, uint16(prev - runStart))
+    } else if container.card <= roaringMaxArray {
+        for n := range(ascendBits(bvTree.bitvector, start, end)) {
+            data = binary.LittleEndian.AppendUint16(data, uint16(n - start))
+        }
+    } else {
+        // Roaring numbers the bits from the bottom of each uint64.
+        for i := uint64(0); i < bitmapContainerUints; i++ {
+            word := uint64(0)
+            if idx := start / 64 + i; idx < uint64(len(bvTree.bitvector)) {
+                word = bits.Reverse64(bvTree.bitvector[idx])
+            }
+            data = binary.LittleEndian.AppendUint64(data, word)
+        }
+    }
+    container.data = data
+    return container, true
+}
+
+/**
+ * Reads a Roaring bitmap in the portable format into a new BvFhTree,
+ * big enough to hold the highest container in the bitmap. A few bytes
+ * can describe a member near 2^32, so the tree's universe is capped at
+ * maxUniverse, and a bitmap needing more returns an error wrapping
+ * ErrOutOfUniverse before anything is allocated.
+ */
+func ReadRoaring(r io.Reader, maxUniverse uint64) (*BvFhTree, error) {
+    br := bufio.NewReader(r)
+    read := func(buf []byte) error {
+        if _, err := io.ReadFull(br, buf); err != nil {
+            return fmt.Errorf("%w: truncated Roaring bitmap: %v", ErrCorrupt, err)
+        }
+        return nil
+    }
+
+    // The cookie tells us whether there are run containers.
+    word := make([]byte, 4)
+    if err := read(word); err != nil {
+        return nil, err
+    }
+    cookie := binary.LittleEndian.Uint32(word)
+    position := 4
+    size := 0
+    var runFlags []byte
+    if cookie & 0xFFFF == roaringCookie {
+        size = int(cookie >> 16) + 1
+        runFlags = make([]byte, (size + 7) / 8)
+        if err := read(runFlags); err != nil {
+            return nil, err
+        }
+        position += len(runFlags)
+    } else if cookie == roaringCookieNoRuns {
+        if err := read(word); err != nil {
+            return nil, err
+        }
+        size = int(binary.LittleEndian.Uint32(word))
+        position += 4
+        if size > 1 << 16 {
+            return nil, fmt.Errorf("%w: %d containers in a Roaring bitmap", ErrCorrupt, size)
+        }
+    } else {
+        return nil, fmt.Errorf("%w: bad Roaring cookie %d", ErrCorrupt, cookie)
+    }
+
+    descriptive := make([]byte, 4 * size)
+    if err := read(descriptive); err != nil {
+        return nil, err
+    }
+    position += len(descriptive)
+    var offsetHeader []byte
+    if runFlags == nil || size >= roaringNoOffsetThreshold {
+        offsetHeader = make([]byte, 4 * size)
+        if err := read(offsetHeader); err != nil {
+            return nil, err
+        }
+        position += len(offsetHeader)
+    }
+
+    // Size the tree to hold the whole of the highest container.
+    numBits := uint64(chunkSize)
+    for i := 0; i < size; i++ {
+        key := uint64(binary.LittleEndian.Uint16(descriptive[4 * i:]))
+        if i > 0 && key <= uint64(binary.LittleEndian.Uint16(descriptive[4 * i - 4:])) {
+            return nil, fmt.Errorf("%w: Roaring container keys aren't sorted", ErrCorrupt)
+        }
+        numBits = (key + 1) << chunkBits
+    }
+    if numBits > maxUniverse {
+        return nil, fmt.Errorf("%w: Roaring bitmap needs a universe of %d, more than %d", ErrOutOfUniverse, numBits, maxUniverse)
+    }
+    result := BuildBvFhTree(numBits)
+
+    for i := 0; i < size; i++ {
+        if offsetHeader != nil && int(binary.LittleEndian.Uint32(offsetHeader[4 * i:])) != position {
+            return nil, fmt.Errorf("%w: Roaring container %d isn't where its offset says", ErrCorrupt, i)
+        }
+
+        key := uint64(binary.LittleEndian.Uint16(descriptive[4 * i:]))
+        card := uint64(binary.LittleEndian.Uint16(descriptive[4 * i + 2:])) + 1
+        base := key << chunkBits
+        set := func(low uint64) {
+            idx, off := offsets(base + low)
+            result.bitvector[idx] |= uint64(1 << (63 - off))
+        }
+
+        var data []byte
+        if runFlags != nil && runFlags[i / 8] & (1 << (i % 8)) != 0 {
+            if err := read(word[:2]); err != nil {
+                return nil, err
+            }
+            numRuns := int(binary.LittleEndian.Uint16(word))
+            data = make([]byte, 4 * numRuns)
+            if err := read(data); err != nil {
+                return nil, err
+            }
+            position += 2
+
+            total, next := uint64(0), uint64(0)
+            for j := 0; j < numRuns; j++ {
+                start := uint64(binary.LittleEndian.Uint16(data[4 * j:]))
+                length := uint64(binary.LittleEndian.Uint16(data[4 * j + 2:])) + 1
+                if (j > 0 && start < next) || start + length > chunkSize {
+                    return nil, fmt.Errorf("%w: Roaring run container %d has a bad run", ErrCorrupt, i)
+                }
+                for low := start; low < start + length; low++ {
+                    set(low)
+                }
+                total += length
+                next = start + length
+            }
+            if total != card {
+                return nil, fmt.Errorf("%w: Roaring run container %d has %d values, expected %d", ErrCorrupt, i, total, card)
+            }
+        } else if card <= roaringMaxArray {
+            data = make([]byte, 2 * card)
+            if err := read(data); err != nil {
+                return nil, err
+            }
+            for j := uint64(0); j < card; j++ {
+                low := uint64(binary.LittleEndian.Uint16(data[2 * j:]))
+                if j > 0 && low <= uint64(binary.LittleEndian.Uint16(data[2 * j - 2:])) {
+                    return nil, fmt.Errorf("%w: Roaring array container %d isn't sorted", ErrCorrupt, i)
+                }
+                set(low)
+            }
+        } else {
+            data = make([]byte, bitmapContainerUints * 8)
+            if err := read(data); err != nil {
+                return nil, err
+            }
+            total := uint64(0)
+            for j := uint64(0); j < bitmapContainerUints; j++ {
+                word := bits.Reverse64(binary.LittleEndian.Uint64(data[8 * j:]))
+                result.bitvector[base / 64 + j] = word
+                total += uint64(bits.OnesCount64(word))
+            }
+            if total != card {
+                return nil, fmt.Errorf("%w: Roaring bitmap container %d has %d values, expected %d", ErrCorrupt, i, total, card)
+            }
+        }
+        position += len(data)
+    }
+
+    result.rebuild()
+    return result, nil
+}
@@ -0,0 +1,266 @@
+package bvtree
+
+import (
+    "fmt"
+    "iter"
+    "math/bits"
+    "sync"
+)
+
+/**
+ * ShardedSet splits the universe into shards by the top bits of the
+ * keys, each a BvFhTree with its own lock, so writers to different
+ * shards don't wait for each other.
+ *
+ * Calls that touch one shard are atomic. Successor, Predecessor, Min,
+ * Max, Len and the iterators move from shard to shard, locking one at
+ * a time, so they don't see the whole set at a single point in time.
+ * They never skip a key that is a member for the whole of the call.
+ */
+type ShardedSet struct {
+
+    // The number of low bits of a key that pick its place in a shard.
+    shift uint
+
+    shards []shard
+}
+
+type shard struct {
+    lock sync.RWMutex
+    tree *BvFhTree
+}
+
+/**
+ * Builds a set over at least numBits keys split into numShards
+ * shards. Each shard covers the same power of four keys.
+ */
+func BuildShardedSet(numBits uint64, numShards int) *ShardedSet {
+    if numShards < 1 {
+        panic(fmt.Sprintf("Can't split a set into %d shards.", numShards))
+    }
+    perShard := (numBits + uint64(numShards) - 1) / uint64(numShards)
+    _, numBvUints := getFhNumUints(perShard)
+
+    result := ShardedSet{}
+    result.shift = uint(bits.TrailingZeros64(numBvUints * 64))
+    result.shards = make([]shard, numShards)
+    for i := range(result.shards) {
+        result.shards[i].tree = BuildBvFhTree(perShard)
+    }
+    return &result
+}
+
+/**
+ * Returns the shard holding n and n's key within it. n has to be
+ * in the universe.
+ */
+func (sharded *ShardedSet) shardOf(n uint64) (*shard, uint64) {
+    return &sharded.shards[n >> sharded.shift], n & (1 << sharded.shift - 1)
+}
+
+func (sharded *ShardedSet) Universe() uint64 {
+    return uint64(len(sharded.shards)) << sharded.shift
+}
+
+/**
+ * Adds up the shards, which are counted one at a time.
+ */
+func (sharded *ShardedSet) Len() uint64 {
+    count := uint64(0)
+    for i := range(sharded.shards) {
+        s := &sharded.shards[i]
+        s.lock.RLock()
+        count += s.tree.Len()
+        s.lock.RUnlock()
+    }
+    return count
+}
+
+func (sharded *ShardedSet) Contains(n uint64) bool {
+    if n >= sharded.Universe() {
+        return false
+    }
+    s, key := sharded.shardOf(n)
+    s.lock.RLock()
+    defer s.lock.RUnlock()
+    return s.tree.Contains(key)
+}
+
+func (sharded *ShardedSet) Insert(n uint64) bool {
+    if err := CheckUniverse(n, sharded.Universe()); err != nil {
+        panic(err)
+    }
+    s, key := sharded.shardOf(n)
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    return s.tree.Insert(key)
+}
+
+func (sharded *ShardedSet) Remove(n uint64) bool {
+    if n >= sharded.Universe() {
+        return false
+    }
+    s, key := sharded.shardOf(n)
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    return s.tree.Remove(key)
+}
+
+func (sharded *ShardedSet) ContainsChecked(n uint64) (bool, error) {
+    if err := CheckUniverse(n, sharded.Universe()); err != nil {
+        return false, err
+    }
+    return sharded.Contains(n), nil
+}
+
+func (sharded *ShardedSet) InsertChecked(n uint64) (bool, error) {
+    if err := CheckUniverse(n, sharded.Universe()); err != nil {
+        return false, err
+    }
+    return sharded.Insert(n), nil
+}
+
+func (sharded *ShardedSet) RemoveChecked(n uint64) (bool, error) {
+    if err := CheckUniverse(n, sharded.Universe()); err != nil {
+        return false, err
+    }
+    return sharded.Remove(n), nil
+}
+
+func (sharded *ShardedSet) Min() uint64 {
+    min, ok := sharded.MinOk()
+    if !ok {
+        panic(ErrEmpty)
+    }
+    return min
+}
+
+func (sharded *ShardedSet) MinOk() (uint64, bool) {
+    return sharded.firstFrom(0)
+}
+
+func (sharded *ShardedSet) Max() uint64 {
+    max, ok := sharded.MaxOk()
+    if !ok {
+        panic(ErrEmpty)
+    }
+    return max
+}
+
+func (sharded *ShardedSet) MaxOk() (uint64, bool) {
+    return sharded.lastIn(len(sharded.shards) - 1)
+}
+
+func (sharded *ShardedSet) Predecessor(n uint64) uint64 {
+    pred, ok := sharded.PredecessorOk(n)
+    if !ok {
+        panic("There was a problem with predecessor.")
+    }
+    return pred
+}
+
+/**
+ * Looks below n in n's own shard, then for the max of each shard
+ * before it.
+ */
+func (sharded *ShardedSet) PredecessorOk(n uint64) (uint64, bool) {
+    if n >= sharded.Universe() {
+        return sharded.MaxOk()
+    }
+
+    s, key := sharded.shardOf(n)
+    s.lock.RLock()
+    pred, ok := s.tree.PredecessorOk(key)
+    s.lock.RUnlock()
+    if ok {
+        return n - key + pred, true
+    }
+    return sharded.lastIn(int(n >> sharded.shift) - 1)
+}
+
+func (sharded *ShardedSet) Successor(n uint64) uint64 {
+    succ, ok := sharded.SuccessorOk(n)
+    if !ok {
+        panic("There was a problem with successor.")
+    }
+    return succ
+}
+
+/**
+ * Looks above n in n's own shard, then for the min of each shard
+ * after it.
+ */
+func (sharded *ShardedSet) SuccessorOk(n uint64) (uint64, bool) {
+    if n >= sharded.Universe() - 1 {
+        return 0, false
+    }
+    return sharded.firstFrom(n + 1)
+}
+
+/**
+ * Returns the first member at or after n.
+ */
+func (sharded *ShardedSet) firstFrom(n uint64) (uint64, bool) {
+    s, key := sharded.shardOf(n)
+    s.lock.RLock()
+    succ, ok := key, s.tree.Contains(key)
+    if !ok {
+        succ, ok = s.tree.SuccessorOk(key)
+    }
+    s.lock.RUnlock()
+    if ok {
+        return n - key + succ, true
+    }
+
+    for i := int(n >> sharded.shift) + 1; i < len(sharded.shards); i++ {
+        s := &sharded.shards[i]
+        s.lock.RLock()
+        min, ok := s.tree.MinOk()
+        s.lock.RUnlock()
+        if ok {
+            return uint64(i) << sharded.shift + min, true
+        }
+    }
+    return 0, false
+}
+
+/**
+ * Returns the max of the last non empty shard at or before i.
+ */
+func (sharded *ShardedSet) lastIn(i int) (uint64, bool) {
+    for ; i >= 0; i-- {
+        s := &sharded.shards[i]
+        s.lock.RLock()
+        max, ok := s.tree.MaxOk()
+        s.lock.RUnlock()
+        if ok {
+            return uint64(i) << sharded.shift + max, true
+        }
+    }
+    return 0, false
+}
+
+func (sharded *ShardedSet) All() iter.Seq[uint64] {
+    return Ascend(sharded, 0, sharded.Universe() - 1)
+}
+
+func (sharded *ShardedSet) Backward() iter.Seq[uint64] {
+    return Descend(sharded, 0, sharded.Universe() - 1)
+}
+
+func (sharded *ShardedSet) Range(lo uint64, hi uint64) iter.Seq[uint64] {
+    if hi == 0 {
+        return func(yield func(uint64) bool) {}
+    }
+    return Ascend(sharded, lo, hi - 1)
+}
+
+func (sharded *ShardedSet) DbgPrint() {
+    for i := range(sharded.shards) {
+        s := &sharded.shards[i]
+        fmt.Printf("shard %d\n", i)
+        s.lock.RLock()
+        s.tree.DbgPrint()
+        s.lock.RUnlock()
+    }
+}
@@ -0,0 +1,256 @@
+package bvtree
+
+import (
+    "iter"
+    "math"
+    "sync"
+)
+
+/**
+ * SyncSet wraps a DynamicSet so it can be shared between goroutines.
+ * Queries take a read lock and run in parallel, Insert and Remove
+ * take the write lock. Each call is atomic on its own, use Do or
+ * View for a sequence of calls that has to be.
+ *
+ * The iterators copy up to syncChunk members at a time under the
+ * read lock, then yield them after it's released, so a loop that
+ * stops early only copies the members it got to. The body of the
+ * loop can call the set, including changing it. Each chunk is the
+ * set as it was when the chunk was copied: the loop sees every
+ * member that stays in the set the whole time exactly once and in
+ * order, but members added or removed while it runs may or may not
+ * be seen. Use View to walk the set as of one moment, as long as the
+ * walk doesn't call back into the SyncSet.
+ */
+type SyncSet struct {
+    lock sync.RWMutex
+    set DynamicSet
+}
+
+/**
+ * Returns a SyncSet over set. Nothing else should use set directly
+ * afterwards.
+ */
+func Synchronized(set DynamicSet) *SyncSet {
+    return &SyncSet{set: set}
+}
+
+/**
+ * Inserts n if it isn't already a member, returns true if it was
+ * inserted. Returns an error instead of panicking if n is outside
+ * of the universe.
+ */
+func (syncSet *SyncSet) InsertIfAbsent(n uint64) (bool, error) {
+    syncSet.lock.Lock()
+    defer syncSet.lock.Unlock()
+    if ok, err := syncSet.set.ContainsChecked(n); ok || err != nil {
+        return false, err
+    }
+    return syncSet.set.InsertChecked(n)
+}
+
+/**
+ * Removes n if it's a member, returns true if it was removed.
+ */
+func (syncSet *SyncSet) RemoveIfPresent(n uint64) (bool, error) {
+    syncSet.lock.Lock()
+    defer syncSet.lock.Unlock()
+    if ok, err := syncSet.set.ContainsChecked(n); !ok || err != nil {
+        return false, err
+    }
+    return syncSet.set.RemoveChecked(n)
+}
+
+/**
+ * Calls fn with the write lock held, everything fn does to the set
+ * is atomic. fn mustn't keep the set after it returns.
+ */
+func (syncSet *SyncSet) Do(fn func(set DynamicSet)) {
+    syncSet.lock.Lock()
+    defer syncSet.lock.Unlock()
+    fn(syncSet.set)
+}
+
+/**
+ * Calls fn with the read lock held, fn sees the set without any
+ * changes in between its queries. fn mustn't change the set.
+ */
+func (syncSet *SyncSet) View(fn func(set DynamicSet)) {
+    syncSet.lock.RLock()
+    defer syncSet.lock.RUnlock()
+    fn(syncSet.set)
+}
+
+func (syncSet *SyncSet) Universe() uint64 {
+    syncSet.lock.RLock()
+    defer syncSet.lock.RUnlock()
+    return syncSet.set.Universe()
+}
+
+func (syncSet *SyncSet) Len() uint64 {
+    syncSet.lock.RLock()
+    defer syncSet.lock.RUnlock()
+    return syncSet.set.Len()
+}
+
+func (syncSet *SyncSet) Contains(n uint64) bool {
+    syncSet.lock.RLock()
+    defer syncSet.lock.RUnlock()
+    return syncSet.set.Contains(n)
+}
+
+func (syncSet *SyncSet) Insert(n uint64) bool {
+    syncSet.lock.Lock()
+    defer syncSet.lock.Unlock()
+    return syncSet.set.Insert(n)
+}
+
+func (syncSet *SyncSet) Remove(n uint64) bool {
+    syncSet.lock.Lock()
+    defer syncSet.lock.Unlock()
+    return syncSet.set.Remove(n)
+}
+
+func (syncSet *SyncSet) ContainsChecked(n uint64) (bool, error) {
+    syncSet.lock.RLock()
+    defer syncSet.lock.RUnlock()
+    return syncSet.set.ContainsChecked(n)
+}
+
+func (syncSet *SyncSet) InsertChecked(n uint64) (bool, error) {
+    syncSet.lock.Lock()
+    defer syncSet.lock.Unlock()
+    return syncSet.set.InsertChecked(n)
+}
+
+func (syncSet *SyncSet) RemoveChecked(n uint64) (bool, error) {
+    syncSet.lock.Lock()
+    defer syncSet.lock.Unlock()
+    return syncSet.set.RemoveChecked(n)
+}
+
+func (syncSet *SyncSet) Predecessor(n uint64) uint64 {
+    syncSet.lock.RLock()
+    defer syncSet.lock.RUnlock()
+    return syncSet.set.Predecessor(n)
+}
+
+func (syncSet *SyncSet) Successor(n uint64) uint64 {
+    syncSet.lock.RLock()
+    defer syncSet.lock.RUnlock()
+    return syncSet.set.Successor(n)
+}
+
+func (syncSet *SyncSet) Min() uint64 {
+    syncSet.lock.RLock()
+    defer syncSet.lock.RUnlock()
+    return syncSet.set.Min()
+}
+
+func (syncSet *SyncSet) Max() uint64 {
+    syncSet.lock.RLock()
+    defer syncSet.lock.RUnlock()
+    return syncSet.set.Max()
+}
+
+func (syncSet *SyncSet) PredecessorOk(n uint64) (uint64, bool) {
+    syncSet.lock.RLock()
+    defer syncSet.lock.RUnlock()
+    return syncSet.set.PredecessorOk(n)
+}
+
+func (syncSet *SyncSet) SuccessorOk(n uint64) (uint64, bool) {
+    syncSet.lock.RLock()
+    defer syncSet.lock.RUnlock()
+    return syncSet.set.SuccessorOk(n)
+}
+
+func (syncSet *SyncSet) MinOk() (uint64, bool) {
+    syncSet.lock.RLock()
+    defer syncSet.lock.RUnlock()
+    return syncSet.set.MinOk()
+}
+
+func (syncSet *SyncSet) MaxOk() (uint64, bool) {
+    syncSet.lock.RLock()
+    defer syncSet.lock.RUnlock()
+    return syncSet.set.MaxOk()
+}
+
+func (syncSet *SyncSet) All() iter.Seq[uint64] {
+    return syncSet.chunked(func() iter.Seq[uint64] { return syncSet.set.All() }, true, 0, math.MaxUint64)
+}
+
+func (syncSet *SyncSet) Backward() iter.Seq[uint64] {
+    return syncSet.chunked(func() iter.Seq[uint64] { return syncSet.set.Backward() }, false, 0, math.MaxUint64)
+}
+
+func (syncSet *SyncSet) Range(lo uint64, hi uint64) iter.Seq[uint64] {
+    if hi <= lo {
+        return func(yield func(uint64) bool) {}
+    }
+    return syncSet.chunked(func() iter.Seq[uint64] { return syncSet.set.Range(lo, hi) }, true, lo, hi - 1)
+}
+
+// How many members the iterators copy each time they take the lock.
+const syncChunk = 256
+
+/**
+ * Returns an iterator that copies the members of the iterator made
+ * by first, syncChunk at a time with the read lock held, and yields
+ * them without it. Holding the lock while the loop body runs would
+ * deadlock a body that calls the set again while a writer is
+ * waiting, as read locks can't be taken recursively.
+ *
+ * After the first chunk, the walk picks up again from the successor
+ * of the last member yielded, or the predecessor going down, staying
+ * inside [lo, hi].
+ */
+func (syncSet *SyncSet) chunked(first func() iter.Seq[uint64], up bool, lo uint64, hi uint64) iter.Seq[uint64] {
+    return func(yield func(uint64) bool) {
+        members := make([]uint64, 0, syncChunk)
+        seq := first
+        for {
+            members = syncSet.collect(members[:0], seq)
+            for _, n := range(members) {
+                if !yield(n) {
+                    return
+                }
+            }
+            if len(members) < syncChunk {
+                return
+            }
+
+            last := members[len(members) - 1]
+            if up && last < hi {
+                seq = func() iter.Seq[uint64] { return Ascend(syncSet.set, last + 1, hi) }
+            } else if !up && last > lo {
+                seq = func() iter.Seq[uint64] { return Descend(syncSet.set, lo, last - 1) }
+            } else {
+                return
+            }
+        }
+    }
+}
+
+/**
+ * Appends up to syncChunk members of the iterator made by seq to
+ * members, with the read lock held.
+ */
+func (syncSet *SyncSet) collect(members []uint64, seq func() iter.Seq[uint64]) []uint64 {
+    syncSet.lock.RLock()
+    defer syncSet.lock.RUnlock()
+    for n := range(seq()) {
+        members = append(members, n)
+        if len(members) == syncChunk {
+            break
+        }
+    }
+    return members
+}
+
+func (syncSet *SyncSet) DbgPrint() {
+    syncSet.lock.RLock()
+    defer syncSet.lock.RUnlock()
+    syncSet.set.DbgPrint()
+}
@@ -0,0 +1,172 @@
+package bvtree
+
+import (
+    "iter"
+    "slices"
+    "sync"
+    "testing"
+)
+
+/**
+ * Tests for SyncSet, meant to be run with the race detector:
+ *
+ *     go test -race -run SyncSet
+ */
+
+/**
+ * countingSet counts the members its iterators and SuccessorOk hand
+ * out, to see how much of the set a SyncSet walk copies.
+ */
+type countingSet struct {
+    DynamicSet
+    seen int
+}
+
+func (set *countingSet) All() iter.Seq[uint64] {
+    return func(yield func(uint64) bool) {
+        for n := range(set.DynamicSet.All()) {
+            set.seen++
+            if !yield(n) {
+                return
+            }
+        }
+    }
+}
+
+func (set *countingSet) SuccessorOk(n uint64) (uint64, bool) {
+    set.seen++
+    return set.DynamicSet.SuccessorOk(n)
+}
+
+func TestSyncSetEarlyStop(t *testing.T) {
+    counted := &countingSet{DynamicSet: BuildBvFhTree(1 << 16)}
+    for n := uint64(0); n < 1 << 16; n += 3 {
+        counted.Insert(n)
+    }
+
+    set := Synchronized(counted)
+    for range(set.All()) {
+        break
+    }
+    if counted.seen > syncChunk {
+        t.Fatalf("Breaking after one member copied %d of them", counted.seen)
+    }
+
+    counted.seen = 0
+    walked := 0
+    for range(set.All()) {
+        walked++
+        if walked == 3 * syncChunk / 2 {
+            break
+        }
+    }
+    if counted.seen > 2 * syncChunk {
+        t.Fatalf("Breaking in the second chunk copied %d members", counted.seen)
+    }
+}
+
+func TestSyncSetChunks(t *testing.T) {
+    tree := BuildBvFhTree(1 << 16)
+    var want []uint64
+    for n := uint64(1); n < 1 << 16; n += 11 {
+        tree.Insert(n)
+        want = append(want, n)
+    }
+    set := Synchronized(tree)
+
+    if got := slices.Collect(set.All()); !slices.Equal(got, want) {
+        t.Fatalf("All walked %d members, expected %d", len(got), len(want))
+    }
+    backward := slices.Collect(set.Backward())
+    slices.Reverse(backward)
+    if !slices.Equal(backward, want) {
+        t.Fatalf("Backward walked %d members, expected %d", len(backward), len(want))
+    }
+
+    // Ranges ending on, just after and just before a member.
+    for _, hi := range([]uint64{want[600], want[600] + 1, want[600] - 1, 1 << 16}) {
+        lo := want[3]
+        var inRange []uint64
+        for _, n := range(want) {
+            if n >= lo && n < hi {
+                inRange = append(inRange, n)
+            }
+        }
+        if got := slices.Collect(set.Range(lo, hi)); !slices.Equal(got, inRange) {
+            t.Fatalf("Range(%d, %d) walked %d members, expected %d", lo, hi, len(got), len(inRange))
+        }
+    }
+    if got := slices.Collect(set.Range(10, 10)); len(got) != 0 {
+        t.Fatalf("An empty range walked %v", got)
+    }
+
+    // Emptying the set from the loop body.
+    for n := range(set.All()) {
+        set.Remove(n)
+    }
+    if set.Len() != 0 {
+        t.Fatalf("%d members left after removing them while walking", set.Len())
+    }
+}
+
+/**
+ * Writers churn the keys that aren't multiples of 8 while readers
+ * walk the set. Every walk must see each multiple of 8 once and in
+ * order, whatever happens around them.
+ */
+func TestSyncSetConcurrent(t *testing.T) {
+    numBits := uint64(1 << 14)
+    set := Synchronized(BuildBvFhTree(numBits))
+    for n := uint64(0); n < numBits; n += 8 {
+        set.Insert(n)
+    }
+
+    var wg sync.WaitGroup
+    for w := uint64(0); w < 4; w++ {
+        wg.Add(2)
+        go func() {
+            defer wg.Done()
+            for j := 0; j < 20; j++ {
+                for n := w + 1; n < numBits; n += 8 {
+                    if j % 2 == 0 {
+                        set.Insert(n)
+                    } else {
+                        set.Remove(n)
+                    }
+                }
+            }
+        }()
+        go func() {
+            defer wg.Done()
+            for j := 0; j < 20; j++ {
+                next := uint64(0)
+                for n := range(set.All()) {
+                    if n % 8 != 0 {
+                        continue
+                    }
+                    if n != next {
+                        t.Errorf("Walked to %d, expected %d", n, next)
+                        return
+                    }
+                    next += 8
+                }
+                if next != numBits {
+                    t.Errorf("Stopped walking at %d", next)
+                    return
+                }
+
+                prev := numBits
+                for n := range(set.Backward()) {
+                    if n % 8 == 0 {
+                        if n != prev - 8 {
+                            t.Errorf("Walked back to %d, expected %d", n, prev - 8)
+                            return
+                        }
+                        prev = n
+                    }
+                }
+            }
+        }()
+    }
+    wg.Wait()
+}
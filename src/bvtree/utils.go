@@ -2,6 +2,7 @@ package bvtree
 
 import (
     "fmt"
+    "math/bits"
 )
 
 func getRoot(n uint64) uint64 {
@@ -28,6 +29,99 @@ func offsets(n uint64) (uint64, uint64) {
     return n / 64, n % 64
 }
 
+/**
+ * Returns the first set bit in [from, to) of the bitvector, looking
+ * at a whole uint64 at a time. Bits are numbered from the top of
+ * each uint64 down, the same as offsets.
+ */
+func nextSetBit(words []uint64, from uint64, to uint64) (uint64, bool) {
+    for from < to {
+        idx, off := offsets(from)
+        base := idx * 64
+        word := words[idx] & (^uint64(0) >> off)
+
+        // Mask out everything from to onwards, if it's in this word.
+        if to - base < 64 {
+            word &= ^(^uint64(0) >> (to - base))
+        }
+
+        if word != 0 {
+            return base + uint64(bits.LeadingZeros64(word)), true
+        }
+        from = base + 64
+    }
+    return 0, false
+}
+
+/**
+ * Returns the last set bit in [from, to) of the bitvector, looking
+ * at a whole uint64 at a time.
+ */
+func prevSetBit(words []uint64, from uint64, to uint64) (uint64, bool) {
+    for to > from {
+        idx, off := offsets(to - 1)
+        base := idx * 64
+        word := words[idx] & ^(^uint64(0) >> (off + 1))
+
+        // Mask out everything before from, if it's in this word.
+        if from > base {
+            word &= ^uint64(0) >> (from - base)
+        }
+
+        if word != 0 {
+            return base + uint64(63 - bits.TrailingZeros64(word)), true
+        }
+        to = base
+    }
+    return 0, false
+}
+
+/**
+ * Returns the number of set bits in [from, to) of the bitvector.
+ */
+func popcountRange(words []uint64, from uint64, to uint64) uint64 {
+    result := uint64(0)
+    for from < to {
+        idx, off := offsets(from)
+        base := idx * 64
+        word := words[idx] & (^uint64(0) >> off)
+        if to - base < 64 {
+            word &= ^(^uint64(0) >> (to - base))
+        }
+        result += uint64(bits.OnesCount64(word))
+        from = base + 64
+    }
+    return result
+}
+
+/**
+ * Returns the position of the k-th set bit (counting from 0) at or
+ * after from in the bitvector. Assumes that there is one.
+ */
+func selectBit(words []uint64, from uint64, k uint64) uint64 {
+    idx, off := offsets(from)
+    word := words[idx] & (^uint64(0) >> off)
+
+    // Skip whole uint64s until the one holding the bit.
+    for c := uint64(bits.OnesCount64(word)); c <= k; c = uint64(bits.OnesCount64(word)) {
+        k -= c
+        idx++
+        word = words[idx]
+    }
+
+    // Then drop the k set bits in front of it.
+    for ; k > 0; k-- {
+        word &= ^uint64(1 << (63 - bits.LeadingZeros64(word)))
+    }
+    return idx * 64 + uint64(bits.LeadingZeros64(word))
+}
+
+// Word operations for the set algebra.
+func orWords(a uint64, b uint64) uint64 { return a | b }
+func andWords(a uint64, b uint64) uint64 { return a & b }
+func andNotWords(a uint64, b uint64) uint64 { return a &^ b }
+func xorWords(a uint64, b uint64) uint64 { return a ^ b }
+
 func dbgPrintBin(n uint64) {
     for i := uint64(0); i < 64; i++ {
         b := uint64(1 << (63 - i))
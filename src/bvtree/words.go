@@ -0,0 +1,546 @@
+package bvtree
+
+import (
+    "fmt"
+)
+
+/**
+ * FhWords is the layout of a BvFhTree over slices the caller owns,
+ * so that sets keeping their words somewhere else, like in fixed
+ * size arrays, run the same code as BvFhTree. The universe is the
+ * bits of Bitvector, split into clusters of SqNumBits keys with one
+ * bit each in Summary.
+ *
+ * The methods take a key in the universe unless they say otherwise,
+ * and keep Summary and ClusterCounts up to date. Only Insert and
+ * Remove use ClusterCounts, a set that can't change may leave it nil.
+ */
+type FhWords struct {
+    SqNumBits uint64
+    ClusterCounts []uint64
+    Summary []uint64
+    Bitvector []uint64
+}
+
+/**
+ * Returns the size of the universe, the number of bits in Bitvector.
+ */
+func (words FhWords) NumBits() uint64 {
+    return uint64(len(words.Bitvector)) * 64
+}
+
+func (words FhWords) Contains(n uint64) bool {
+    idx, off := offsets(n)
+    return words.Bitvector[idx] & uint64(1 << (63 - off)) != 0
+}
+
+/**
+ * Sets n's bit, and its cluster's bit in the summary. Returns
+ * false if n was already there.
+ */
+func (words FhWords) Insert(n uint64) bool {
+    idx, off := offsets(n)
+    b := uint64(1 << (63 - off))
+    if words.Bitvector[idx] & b != 0 {
+        return false
+    }
+    words.Bitvector[idx] |= b
+
+    sIdx := n / words.SqNumBits
+    words.ClusterCounts[sIdx]++
+    idx, off = offsets(sIdx)
+    words.Summary[idx] |= uint64(1 << (63 - off))
+    return true
+}
+
+/**
+ * Clears n's bit, and its cluster's bit in the summary if that was
+ * the last member of the cluster. Returns false if n wasn't there.
+ */
+func (words FhWords) Remove(n uint64) bool {
+    idx, off := offsets(n)
+    b := uint64(1 << (63 - off))
+    if words.Bitvector[idx] & b == 0 {
+        return false
+    }
+    words.Bitvector[idx] &= ^b
+
+    sIdx := n / words.SqNumBits
+    words.ClusterCounts[sIdx]--
+    if words.ClusterCounts[sIdx] == 0 {
+        idx, off = offsets(sIdx)
+        words.Summary[idx] &= ^uint64(1 << (63 - off))
+    }
+    return true
+}
+
+func (words FhWords) MinOk() (uint64, bool) {
+    sIdx, ok := nextSetBit(words.Summary, 0, words.numClusters())
+    if !ok {
+        return 0, false
+    }
+    return words.clusterMin(sIdx)
+}
+
+func (words FhWords) MaxOk() (uint64, bool) {
+    sIdx, ok := prevSetBit(words.Summary, 0, words.numClusters())
+    if !ok {
+        return 0, false
+    }
+    return words.clusterMax(sIdx)
+}
+
+/**
+ * Returns the member after n, n can be any uint64.
+ */
+func (words FhWords) SuccessorOk(n uint64) (uint64, bool) {
+    if n >= words.NumBits() - 1 {
+        return 0, false
+    }
+    return words.firstFrom(n + 1)
+}
+
+/**
+ * Returns the member before n, n can be any uint64.
+ */
+func (words FhWords) PredecessorOk(n uint64) (uint64, bool) {
+    return words.lastBefore(min(n, words.NumBits()))
+}
+
+/**
+ * Returns the first member at or after n, looking at the rest of
+ * n's cluster then the first non empty cluster after it.
+ */
+func (words FhWords) firstFrom(n uint64) (uint64, bool) {
+    sIdx := n / words.SqNumBits
+    if succ, ok := nextSetBit(words.Bitvector, n, (sIdx + 1) * words.SqNumBits); ok {
+        return succ, true
+    }
+    sIdx, ok := nextSetBit(words.Summary, sIdx + 1, words.numClusters())
+    if !ok {
+        return 0, false
+    }
+    return words.clusterMin(sIdx)
+}
+
+/**
+ * Returns the last member before n.
+ */
+func (words FhWords) lastBefore(n uint64) (uint64, bool) {
+    if n == 0 {
+        return 0, false
+    }
+    sIdx := (n - 1) / words.SqNumBits
+    if pred, ok := prevSetBit(words.Bitvector, sIdx * words.SqNumBits, n); ok {
+        return pred, true
+    }
+    sIdx, ok := prevSetBit(words.Summary, 0, sIdx)
+    if !ok {
+        return 0, false
+    }
+    return words.clusterMax(sIdx)
+}
+
+func (words FhWords) numClusters() uint64 {
+    return words.NumBits() / words.SqNumBits
+}
+
+/**
+ * Returns the smallest value in the cluster at the given
+ * index into the summary bitvector.
+ */
+func (words FhWords) clusterMin(sIdx uint64) (uint64, bool) {
+    return nextSetBit(words.Bitvector, sIdx * words.SqNumBits, (sIdx + 1) * words.SqNumBits)
+}
+
+/**
+ * Returns the largest value in the cluster at the given
+ * index into the summary bitvector.
+ */
+func (words FhWords) clusterMax(sIdx uint64) (uint64, bool) {
+    return prevSetBit(words.Bitvector, sIdx * words.SqNumBits, (sIdx + 1) * words.SqNumBits)
+}
+
+func (words FhWords) DbgPrint() {
+    fmt.Println("DbgPrint: ")
+    fmt.Println("suptree")
+    for _, val := range(words.Summary) {
+        dbgPrintBin(val)
+    }
+    fmt.Println("\nbitvector")
+    for _, val := range(words.Bitvector) {
+        dbgPrintBin(val)
+    }
+    fmt.Println(" ")
+}
+
+/**
+ * BvWords is the layout of a BvTree over slices the caller owns,
+ * the same as FhWords is for BvFhTree. Bitvector holds the universe,
+ * a power of two of at least 64 bits, and Suptree the supporting
+ * binary tree over it, with as many uint64s as Bitvector.
+ *
+ * The methods take a key in the universe unless they say otherwise,
+ * and keep Suptree up to date.
+ */
+type BvWords struct {
+    Suptree []uint64
+    Bitvector []uint64
+}
+
+/**
+ * Returns the size of the universe, the number of bits in Bitvector.
+ */
+func (words BvWords) NumBits() uint64 {
+    return uint64(len(words.Bitvector)) * 64
+}
+
+func (words BvWords) Contains(n uint64) bool {
+    return words.hasBvBit(n)
+}
+
+/**
+ * Sets n's bit, and the path above it in the supporting tree.
+ * Returns false if n was already there.
+ */
+func (words BvWords) Insert(n uint64) bool {
+    idx, off := offsets(n)
+    b := uint64(1 << (63 - off))
+    if (words.Bitvector[idx] & b) != 0 {
+        return false
+    }
+    words.Bitvector[idx] |= b
+
+    // Update the supporting binary tree.
+    words.markPath(n)
+    return true
+}
+
+/**
+ * Clears n's bit, and the nodes above it in the supporting tree
+ * that have nothing left under them. Returns false if n wasn't
+ * there.
+ */
+func (words BvWords) Remove(n uint64) bool {
+    if !words.hasBvBit(n) {
+        return false
+    }
+
+    // Rmove from the bitvector
+    idx, off := offsets(n)
+    b := ^uint64(1 << (63 - off))
+    words.Bitvector[idx] &= b
+
+    cIdx := words.supIndex(n)
+    idx, off = offsets(cIdx)
+    b = ^uint64(1 << (63 - off))
+
+    rIdx, rOff := offsets((n / 2) * 2)
+    lIdx, lOff := offsets((n / 2) * 2 + 1)
+
+    rVal := words.Bitvector[rIdx] & uint64(1 << (63 - rOff))
+    lVal := words.Bitvector[lIdx] & uint64(1 << (63 - lOff))
+    if (rVal == 0 && lVal == 0) {
+        words.Suptree[idx] &= b
+    }
+
+    cIdx = parentIndex(cIdx)
+    for cIdx > 0 {
+        idx, off := offsets(cIdx)
+        // bit to clear.
+        b = ^uint64(1 << (63 - off))
+
+        // Values of left and right children.
+        rPos := rightIndex(cIdx)
+        lPos := leftIndex(cIdx)
+        rIdx, rOff = offsets(rPos)
+        lIdx, lOff = offsets(lPos)
+
+        rVal := words.Suptree[rIdx] & uint64(1 << (63 - rOff))
+        lVal := words.Suptree[lIdx] & uint64(1 << (63 - lOff))
+        if (rVal == 0 && lVal == 0) {
+            words.Suptree[idx] &= b
+        }
+
+        cIdx = parentIndex(cIdx)
+    }
+
+    // Clear out the root node if theres no data left.
+    fb := words.Suptree[0]
+    if fb == (uint64(1 << 63))  {
+        words.Suptree[0] = 0
+    }
+    return true
+}
+
+func (words BvWords) MinOk() (uint64, bool) {
+    cPos := uint64(0)
+    if words.zeroRoot() {
+        return 0, false
+    }
+
+    for cPos < words.llIndex() {
+        rPos := rightIndex(cPos)
+        lPos := leftIndex(cPos)
+        lIdx, lOff := offsets(lPos)
+
+        lVal := words.Suptree[lIdx] & uint64(1 << (63 - lOff))
+
+        if lVal != 0 {
+            cPos = lPos
+        } else {
+            cPos = rPos
+        }
+    }
+
+    // Now that we're outside that loop, we need to 
+    // reach into the bitvector.
+    lPos, rPos := words.bvIndices(cPos)
+    lIdx, lOff := offsets(lPos)
+    lVal := words.Bitvector[lIdx] & uint64(1 << (63 - lOff))
+
+    if lVal != 0 {
+        return lPos, true
+    }
+
+    return rPos, true
+}
+
+func (words BvWords) MaxOk() (uint64, bool) {
+    cPos := uint64(0)
+    if words.zeroRoot() {
+        return 0, false
+    }
+
+    for cPos < words.llIndex() {
+        rPos := rightIndex(cPos)
+        lPos := leftIndex(cPos)
+        rIdx, rOff := offsets(rPos)
+
+        rVal := words.Suptree[rIdx] & uint64(1 << (63 - rOff))
+
+        if rVal != 0 {
+            cPos = rPos
+        } else {
+            cPos = lPos
+        }
+
+    }
+
+    // Now that we're outside that loop, we need to 
+    // reach into the bitvector.
+    lPos, rPos := words.bvIndices(cPos)
+    rIdx, rOff := offsets(rPos)
+    rVal := words.Bitvector[rIdx] & uint64(1 << (63 - rOff))
+
+    if rVal != 0 {
+        return rPos, true
+    }
+
+    return lPos, true
+}
+
+func (words BvWords) PredecessorOk(n uint64) (uint64, bool) {
+    // Everything in the tree is below n.
+    if n >= words.NumBits() {
+        return words.MaxOk()
+    }
+
+    treePos := words.supIndex(n)
+    goingUp := true
+
+    //Now, the normal case, we have to keep searching up the tree.
+    for treePos <= words.maxLlIndex() {
+        //fmt.Printf("treePos %d\n", treePos)
+        // If we're at the lowest level, check whether the right child has a bit
+        // and make sure that we're not just returning n.
+        if words.inLowestLevel(treePos) {
+        lPos, rPos := words.bvIndices(treePos)
+            if rPos < n && words.hasBvBit(rPos) {
+                return rPos, true
+            } else if lPos < n && words.hasBvBit(lPos) {
+                return lPos, true
+            }
+        }
+
+        // If we didn't find the successor, we need to traverse the tree.
+
+        if goingUp {
+            // Climbed all the way to the root without finding anything.
+            if treePos == 0 {
+                break
+            }
+            nextLeftPos := leftIndex(parentIndex(treePos))
+
+            if nextLeftPos != treePos  && words.hasStBit(nextLeftPos) {
+                treePos = nextLeftPos
+                goingUp = false
+            } else {
+                treePos = parentIndex(treePos)
+            }
+        } else {
+            // When going down the tree, just look right, if nothing, go left
+            nextRightPos, nextLeftPos := words.childrenIndices(treePos)
+            if words.hasStBit(nextLeftPos) {
+                treePos = nextLeftPos
+            } else {
+                treePos = nextRightPos
+            }
+        }
+    }
+
+    return 0, false
+}
+
+func (words BvWords) SuccessorOk(n uint64) (uint64, bool) {
+    if n >= words.NumBits() {
+        return 0, false
+    }
+
+    treePos := words.supIndex(n)
+    goingUp := true
+
+    //Now, the normal case, we have to keep searching up the tree.
+    for treePos <= words.maxLlIndex() {
+        //fmt.Printf("treePos %d\n", treePos)
+        // If we're at the lowest level, check whether the right child has a bit
+        // and make sure that we're not just returning n.
+        if words.inLowestLevel(treePos) {
+        lPos, rPos := words.bvIndices(treePos)
+            if lPos > n && words.hasBvBit(lPos) {
+                return lPos, true
+            } else if rPos > n && words.hasBvBit(rPos) {
+                return rPos, true
+            }
+        }
+
+        // If we didn't find the successor, we need to traverse the tree.
+
+        if goingUp {
+            // Climbed all the way to the root without finding anything.
+            if treePos == 0 {
+                break
+            }
+            nextRightPos := rightIndex(parentIndex(treePos))
+
+            if nextRightPos != treePos  && words.hasStBit(nextRightPos) {
+                treePos = nextRightPos
+                goingUp = false
+            } else {
+                treePos = parentIndex(treePos)
+            }
+        } else {
+            // When going down the tree, just look right, if nothing, go left
+            nextRightPos, nextLeftPos := words.childrenIndices(treePos)
+            if words.hasStBit(nextRightPos) {
+                treePos = nextRightPos
+            } else {
+                treePos = nextLeftPos
+            }
+        }
+    }
+
+    return 0, false
+}
+
+func (words BvWords) zeroRoot() bool {
+    fb := words.Suptree[0] & uint64(1 << 63)
+    return fb == 0
+}
+
+/**
+ * Returns true if the position is in the lowest level
+ * of the tree (so that it's children will be in the bitvector)
+ */
+func (words BvWords) inLowestLevel(pos uint64) bool {
+    return (pos >= words.llIndex() && pos <= words.maxLlIndex())
+}
+
+// Return true if the supporting tree has the bit.
+func (words BvWords) hasStBit(pos uint64) bool {
+    idx, off := offsets(pos)
+    return (words.Suptree[idx] & uint64(1 << (63 - off))) != 0
+}
+
+// Return true if the bitvector has the bit.
+func (words BvWords) hasBvBit(pos uint64) bool {
+    idx, off := offsets(pos)
+    return (words.Bitvector[idx] & uint64(1 << (63 - off))) != 0
+}
+
+// Assuming the bitvector is of size 2^n, get the first index of the
+// last level in the supporting tree.
+// E.G. 
+func (words BvWords) llIndex() uint64 {
+    return words.NumBits() / 2 - 1
+}
+
+/**
+ * Returns the last index in the lower level of the supporting tree.
+ */
+func (words BvWords) maxLlIndex() uint64 {
+    return words.NumBits() - 2
+}
+
+/**
+ * Given an index at the lowest level of the support tree,
+ * returns the left and right "children" indices inside
+ * the bit vector.
+ */
+func (words BvWords) bvIndices(n uint64) (uint64, uint64) {
+    k := (n - (words.NumBits() / 2 - 1)) * 2
+    return k, k + 1
+}
+
+/**
+ * Assuming that n is inside the support tree, returns the indices
+ * of its left and right children
+ */
+func (words BvWords) childrenIndices(n uint64) (uint64, uint64) {
+    return leftIndex(n), rightIndex(n)
+}
+
+func (words BvWords) supIndex(n uint64) uint64 {
+    return (words.NumBits() / 2 - 1) + (n / 2)
+}
+
+/**
+ * Sets the bits in the supporting binary tree from n's node up
+ * to the root, stopping early at a node that's already set.
+ */
+func (words BvWords) markPath(n uint64) {
+    sIdx := words.supIndex(n)
+    for sIdx > 0 && !words.hasStBit(sIdx) {
+        idx, off := offsets(sIdx)
+        b := uint64(1 << (63 - off))
+        words.Suptree[idx] |= b
+        sIdx = parentIndex(sIdx)
+    }
+    words.Suptree[0] |= (1 << 63)
+}
+
+/**
+ * Clears the path of the supporting tree above n, stopping at the
+ * first node that's already clear.
+ */
+func (words BvWords) unmarkPath(n uint64) {
+    sIdx := words.supIndex(n)
+    for sIdx > 0 && words.hasStBit(sIdx) {
+        idx, off := offsets(sIdx)
+        words.Suptree[idx] &= ^uint64(1 << (63 - off))
+        sIdx = parentIndex(sIdx)
+    }
+}
+
+func (words BvWords) DbgPrint() {
+    fmt.Println("DbgPrint: ")
+    fmt.Println("suptree")
+    //for _, val := range(words.Suptree) {
+    //    dbgPrintBin(val)
+    //}
+    fmt.Println("\nbitvector")
+    //for _, val := range(words.Bitvector) {
+    //    dbgPrintBin(val)
+    //}
+    fmt.Println(" ")
+}
@@ -0,0 +1,191 @@
+package fasttrie
+
+/**
+ * treap is a binary search tree kept balanced by heap ordering
+ * random priorities. The priorities are a hash of the keys rather
+ * than drawn from a generator, so the shape of a bucket only
+ * depends on its keys and runs are reproducible.
+ */
+type treap struct {
+    root *treapNode
+    size uint64
+}
+
+type treapNode struct {
+    key uint64
+    priority uint64
+    left *treapNode
+    right *treapNode
+}
+
+// The splitmix64 finalizer, a cheap hash that spreads every bit of the key.
+func treapPriority(key uint64) uint64 {
+    key ^= key >> 30
+    key *= 0xbf58476d1ce4e5b9
+    key ^= key >> 27
+    key *= 0x94d049bb133111eb
+    key ^= key >> 31
+    return key
+}
+
+func (t *treap) contains(x uint64) bool {
+    node := t.root
+    for node != nil && node.key != x {
+        if x < node.key {
+            node = node.left
+        } else {
+            node = node.right
+        }
+    }
+    return node != nil
+}
+
+/**
+ * Inserts x, returns true if it wasn't already there.
+ */
+func (t *treap) insert(x uint64) bool {
+    var added bool
+    t.root, added = insertTreapNode(t.root, x)
+    if added {
+        t.size++
+    }
+    return added
+}
+
+func insertTreapNode(node *treapNode, x uint64) (*treapNode, bool) {
+    if node == nil {
+        return &treapNode{key: x, priority: treapPriority(x)}, true
+    }
+
+    var added bool
+    switch {
+    case x < node.key:
+        node.left, added = insertTreapNode(node.left, x)
+        if node.left.priority > node.priority {
+            node = rotateRight(node)
+        }
+    case x > node.key:
+        node.right, added = insertTreapNode(node.right, x)
+        if node.right.priority > node.priority {
+            node = rotateLeft(node)
+        }
+    }
+    return node, added
+}
+
+/**
+ * Removes x, returns true if it was there.
+ */
+func (t *treap) remove(x uint64) bool {
+    var removed bool
+    t.root, removed = removeTreapNode(t.root, x)
+    if removed {
+        t.size--
+    }
+    return removed
+}
+
+func removeTreapNode(node *treapNode, x uint64) (*treapNode, bool) {
+    if node == nil {
+        return nil, false
+    }
+
+    var removed bool
+    switch {
+    case x < node.key:
+        node.left, removed = removeTreapNode(node.left, x)
+    case x > node.key:
+        node.right, removed = removeTreapNode(node.right, x)
+    default:
+        // Rotate the node down until it's a leaf, then drop it.
+        switch {
+        case node.left == nil:
+            return node.right, true
+        case node.right == nil:
+            return node.left, true
+        case node.left.priority > node.right.priority:
+            node = rotateRight(node)
+            node.right, removed = removeTreapNode(node.right, x)
+        default:
+            node = rotateLeft(node)
+            node.left, removed = removeTreapNode(node.left, x)
+        }
+    }
+    return node, removed
+}
+
+func rotateRight(node *treapNode) *treapNode {
+    left := node.left
+    node.left, left.right = left.right, node
+    return left
+}
+
+func rotateLeft(node *treapNode) *treapNode {
+    right := node.right
+    node.right, right.left = right.left, node
+    return right
+}
+
+func (t *treap) min() uint64 {
+    node := t.root
+    for node.left != nil {
+        node = node.left
+    }
+    return node.key
+}
+
+func (t *treap) max() uint64 {
+    node := t.root
+    for node.right != nil {
+        node = node.right
+    }
+    return node.key
+}
+
+/**
+ * Returns the first key above x, and false if there's none.
+ */
+func (t *treap) successor(x uint64) (uint64, bool) {
+    result, ok := uint64(0), false
+    for node := t.root; node != nil; {
+        if node.key > x {
+            result, ok = node.key, true
+            node = node.left
+        } else {
+            node = node.right
+        }
+    }
+    return result, ok
+}
+
+/**
+ * Returns the last key below x, and false if there's none.
+ */
+func (t *treap) predecessor(x uint64) (uint64, bool) {
+    result, ok := uint64(0), false
+    for node := t.root; node != nil; {
+        if node.key < x {
+            result, ok = node.key, true
+            node = node.right
+        } else {
+            node = node.left
+        }
+    }
+    return result, ok
+}
+
+/**
+ * Appends the keys to dst in ascending order.
+ */
+func (t *treap) appendKeys(dst []uint64) []uint64 {
+    return appendTreapKeys(dst, t.root)
+}
+
+func appendTreapKeys(dst []uint64, node *treapNode) []uint64 {
+    if node == nil {
+        return dst
+    }
+    dst = appendTreapKeys(dst, node.left)
+    dst = append(dst, node.key)
+    return appendTreapKeys(dst, node.right)
+}
@@ -0,0 +1,461 @@
+package fasttrie
+
+import (
+    "../bvtree"
+    "fmt"
+    "iter"
+    "math/bits"
+)
+
+/**
+ * XFastTrie is a set of integers between 0 and n for any n up to
+ * 2^64, stored as a binary trie over the bits of the keys with a
+ * hash table per level.
+ *
+ * Level l holds a node for every l bit prefix of a member, and the
+ * leaves at the bottom level are threaded into a sorted doubly
+ * linked list. An internal node missing a child points instead to
+ * the leaf closest to that side, the min of its right subtree or
+ * the max of its left one. Finding a key's deepest ancestor is a
+ * binary search over the levels, so queries take O(log w) hash
+ * lookups and updates O(w), in O(nw) memory.
+ */
+type XFastTrie struct {
+
+    // The number of bits in a key, the universe is 2^w.
+    w uint
+
+    // The number of members in the set.
+    count uint64
+
+    // The nodes of each level by their prefix, levels[w] is the leaves.
+    levels []map[uint64]*xNode
+
+    // The ends of the list of leaves.
+    head *xNode
+    tail *xNode
+}
+
+/**
+ * xNode is a node of the trie. On leaves, left and right are the
+ * previous and next leaves.
+ */
+type xNode struct {
+    key uint64
+    left *xNode
+    right *xNode
+
+    // Which of left and right are real children rather than
+    // threads to a leaf.
+    children uint8
+}
+
+const (
+    leftChild uint8 = 1 << iota
+    rightChild
+)
+
+func BuildXFastTrie(numBits uint64) *XFastTrie {
+    result := XFastTrie{}
+    result.w = getBits(numBits)
+    result.levels = make([]map[uint64]*xNode, result.w + 1)
+    for i := range(result.levels) {
+        result.levels[i] = make(map[uint64]*xNode)
+    }
+    return &result
+}
+
+// Returns the l bit prefix of x.
+func (trie *XFastTrie) prefix(x uint64, l uint) uint64 {
+    return x >> (trie.w - l)
+}
+
+// Returns the bit of x below its l bit prefix.
+func (trie *XFastTrie) bit(x uint64, l uint) uint64 {
+    return (x >> (trie.w - l - 1)) & 1
+}
+
+/**
+ * Returns the deepest node whose prefix is shared with x, and its
+ * level. Assumes that the trie isn't empty, so the root is there.
+ */
+func (trie *XFastTrie) ancestor(x uint64) (*xNode, uint) {
+    lo, hi := uint(0), trie.w
+    for lo < hi {
+        mid := (lo + hi + 1) / 2
+        if _, ok := trie.levels[mid][trie.prefix(x, mid)]; ok {
+            lo = mid
+        } else {
+            hi = mid - 1
+        }
+    }
+    return trie.levels[lo][trie.prefix(x, lo)], lo
+}
+
+/**
+ * Returns the leaf of the first member >= x, or nil if there's none.
+ */
+func (trie *XFastTrie) ceiling(x uint64) *xNode {
+    if trie.count == 0 {
+        return nil
+    }
+    node, l := trie.ancestor(x)
+    if l == trie.w {
+        return node
+    }
+
+    // x branches off below node, into the side that's missing.
+    if trie.bit(x, l) == 0 {
+        return node.left
+    }
+    return node.right.right
+}
+
+/**
+ * Returns the leaf of the last member <= x, or nil if there's none.
+ */
+func (trie *XFastTrie) floor(x uint64) *xNode {
+    if trie.count == 0 {
+        return nil
+    }
+    node, l := trie.ancestor(x)
+    if l == trie.w {
+        return node
+    }
+    if trie.bit(x, l) == 1 {
+        return node.right
+    }
+    return node.left.left
+}
+
+func (trie *XFastTrie) Min() uint64 {
+    min, ok := trie.MinOk()
+    if !ok {
+        panic(bvtree.ErrEmpty)
+    }
+    return min
+}
+
+func (trie *XFastTrie) MinOk() (uint64, bool) {
+    if trie.head == nil {
+        return 0, false
+    }
+    return trie.head.key, true
+}
+
+func (trie *XFastTrie) Max() uint64 {
+    max, ok := trie.MaxOk()
+    if !ok {
+        panic(bvtree.ErrEmpty)
+    }
+    return max
+}
+
+func (trie *XFastTrie) MaxOk() (uint64, bool) {
+    if trie.tail == nil {
+        return 0, false
+    }
+    return trie.tail.key, true
+}
+
+/**
+ * Returns the number below n in the trie.
+ * Assumes that the number passed in is greater than
+ * the min value.
+ */
+func (trie *XFastTrie) Predecessor(n uint64) uint64 {
+    pred, ok := trie.PredecessorOk(n)
+    if !ok {
+        panic("There was a problem with predecessor.")
+    }
+    return pred
+}
+
+/**
+ * Returns the number below n in the trie, and false if
+ * there is no such number.
+ */
+func (trie *XFastTrie) PredecessorOk(n uint64) (uint64, bool) {
+    // Everything in the trie is below n.
+    if !trie.inUniverse(n) {
+        return trie.MaxOk()
+    }
+    leaf := trie.floor(n)
+    if leaf != nil && leaf.key == n {
+        leaf = leaf.left
+    }
+    if leaf == nil {
+        return 0, false
+    }
+    return leaf.key, true
+}
+
+/**
+ * Returns the number above n in the trie.
+ * Assumes that the number passed in is less than
+ * the max value.
+ */
+func (trie *XFastTrie) Successor(n uint64) uint64 {
+    succ, ok := trie.SuccessorOk(n)
+    if !ok {
+        panic("There was a problem with successor.")
+    }
+    return succ
+}
+
+/**
+ * Returns the number above n in the trie, and false if
+ * there is no such number.
+ */
+func (trie *XFastTrie) SuccessorOk(n uint64) (uint64, bool) {
+    if !trie.inUniverse(n) {
+        return 0, false
+    }
+    leaf := trie.ceiling(n)
+    if leaf != nil && leaf.key == n {
+        leaf = leaf.right
+    }
+    if leaf == nil {
+        return 0, false
+    }
+    return leaf.key, true
+}
+
+// Returns true if n fits in the w bits of the trie's keys.
+func (trie *XFastTrie) inUniverse(n uint64) bool {
+    return trie.w == 64 || n >> trie.w == 0
+}
+
+/**
+ * returns true if the trie contains the given uint64.
+ */
+func (trie *XFastTrie) Contains(n uint64) bool {
+    _, ok := trie.levels[trie.w][n]
+    return ok
+}
+
+/**
+ * Inserts the integer n into the trie, returns true if
+ * it wasn't already there.
+ */
+func (trie *XFastTrie) Insert(n uint64) bool {
+    added, err := trie.InsertChecked(n)
+    if err != nil {
+        panic(err)
+    }
+    return added
+}
+
+/**
+ * Removes the integer n from the trie, returns true if
+ * it was there.
+ */
+func (trie *XFastTrie) Remove(n uint64) bool {
+    leaf, ok := trie.levels[trie.w][n]
+    if !ok {
+        return false
+    }
+    trie.remove(leaf)
+    return true
+}
+
+/**
+ * returns true if the trie contains n, or an error if n
+ * is outside of the universe.
+ */
+func (trie *XFastTrie) ContainsChecked(n uint64) (bool, error) {
+    if err := bvtree.CheckUniverse(n, trie.Universe()); err != nil {
+        return false, err
+    }
+    return trie.Contains(n), nil
+}
+
+/**
+ * Inserts n, returning an error instead of panicking if n
+ * is outside of the universe.
+ */
+func (trie *XFastTrie) InsertChecked(n uint64) (bool, error) {
+    if err := bvtree.CheckUniverse(n, trie.Universe()); err != nil {
+        return false, err
+    }
+    if trie.Contains(n) {
+        return false, nil
+    }
+    trie.insert(n)
+    return true, nil
+}
+
+/**
+ * Removes n, returning an error if n is outside of the universe.
+ */
+func (trie *XFastTrie) RemoveChecked(n uint64) (bool, error) {
+    if err := bvtree.CheckUniverse(n, trie.Universe()); err != nil {
+        return false, err
+    }
+    return trie.Remove(n), nil
+}
+
+/**
+ * Adds the leaf for x, which must not be a member, then the
+ * missing prefixes of x from the bottom up.
+ */
+func (trie *XFastTrie) insert(x uint64) {
+    leaf := &xNode{key: x}
+
+    // Thread the leaf in between its neighbours.
+    if pred := trie.floor(x); pred != nil {
+        leaf.left, leaf.right = pred, pred.right
+    } else {
+        leaf.right = trie.head
+    }
+    if leaf.left != nil {
+        leaf.left.right = leaf
+    } else {
+        trie.head = leaf
+    }
+    if leaf.right != nil {
+        leaf.right.left = leaf
+    } else {
+        trie.tail = leaf
+    }
+    trie.levels[trie.w][x] = leaf
+    trie.count++
+
+    child := leaf
+    for l := int(trie.w) - 1; l >= 0; l-- {
+        p := trie.prefix(x, uint(l))
+        node, ok := trie.levels[l][p]
+        if !ok {
+            node = &xNode{key: p}
+            trie.levels[l][p] = node
+        }
+
+        if trie.bit(x, uint(l)) == 0 {
+            node.left = child
+            node.children |= leftChild
+            // x is now the max of the left subtree if the right is missing.
+            if node.children & rightChild == 0 && (node.right == nil || node.right.key < x) {
+                node.right = leaf
+            }
+        } else {
+            node.right = child
+            node.children |= rightChild
+            if node.children & leftChild == 0 && (node.left == nil || node.left.key > x) {
+                node.left = leaf
+            }
+        }
+        child = node
+    }
+}
+
+/**
+ * Unthreads the leaf and drops the prefixes only it had, from the
+ * bottom up, fixing the threads that pointed at it.
+ */
+func (trie *XFastTrie) remove(leaf *xNode) {
+    x := leaf.key
+    if leaf.left != nil {
+        leaf.left.right = leaf.right
+    } else {
+        trie.head = leaf.right
+    }
+    if leaf.right != nil {
+        leaf.right.left = leaf.left
+    } else {
+        trie.tail = leaf.left
+    }
+    delete(trie.levels[trie.w], x)
+    trie.count--
+
+    // The node removed from the level below, if any.
+    removed := leaf
+    for l := int(trie.w) - 1; l >= 0; l-- {
+        p := trie.prefix(x, uint(l))
+        node := trie.levels[l][p]
+        if removed != nil {
+            if trie.bit(x, uint(l)) == 0 {
+                node.children &= ^leftChild
+            } else {
+                node.children &= ^rightChild
+            }
+        }
+
+        if node.children == 0 {
+            delete(trie.levels[l], p)
+            removed = node
+            continue
+        }
+
+        // A missing side pointing at x, or at the child that just
+        // went, moves to x's neighbour in the subtree that's left.
+        if node.children & leftChild == 0 && (node.left == leaf || node.left == removed) {
+            node.left = leaf.right
+        }
+        if node.children & rightChild == 0 && (node.right == leaf || node.right == removed) {
+            node.right = leaf.left
+        }
+        removed = nil
+    }
+}
+
+/**
+ * Returns the number of members in the trie.
+ */
+func (trie *XFastTrie) Len() uint64 {
+    return trie.count
+}
+
+/**
+ * Returns an iterator over the members in ascending order.
+ */
+func (trie *XFastTrie) All() iter.Seq[uint64] {
+    return bvtree.Ascend(trie, 0, trie.Universe() - 1)
+}
+
+/**
+ * Returns an iterator over the members in descending order.
+ */
+func (trie *XFastTrie) Backward() iter.Seq[uint64] {
+    return bvtree.Descend(trie, 0, trie.Universe() - 1)
+}
+
+/**
+ * Returns an iterator over the members in [lo, hi), in
+ * ascending order.
+ */
+func (trie *XFastTrie) Range(lo uint64, hi uint64) iter.Seq[uint64] {
+    if hi == 0 {
+        return func(yield func(uint64) bool) {}
+    }
+    return bvtree.Ascend(trie, lo, hi - 1)
+}
+
+/**
+ * Returns the size of the universe, 2^w. A trie over all
+ * 64 bit keys returns 0.
+ */
+func (trie *XFastTrie) Universe() uint64 {
+    return uint64(1) << trie.w
+}
+
+func (trie *XFastTrie) DbgPrint() {
+    fmt.Println("DbgPrint: ")
+    for l, level := range(trie.levels) {
+        fmt.Printf("level %d: %d nodes\n", l, len(level))
+    }
+    for leaf := trie.head; leaf != nil; leaf = leaf.right {
+        fmt.Printf("%d ", leaf.key)
+    }
+    fmt.Println(" ")
+}
+
+/**
+ * Returns the number of bits needed for a key in a
+ * universe of numBits values.
+ */
+func getBits(numBits uint64) uint {
+    if numBits <= 1 {
+        return 1
+    }
+    return uint(bits.Len64(numBits - 1))
+}
@@ -0,0 +1,362 @@
+package fasttrie
+
+import (
+    "../bvtree"
+    "fmt"
+    "iter"
+)
+
+/**
+ * YFastTrie is a set of integers between 0 and n for any n up to
+ * 2^64, which keeps the members in buckets of about w keys and only
+ * puts one representative per bucket in an x-fast trie.
+ *
+ * Each bucket is a treap holding the members between the previous
+ * bucket's representative (exclusive) and its own (inclusive). The
+ * last representative is the top of the universe, so every key has a
+ * bucket. Buckets are split when they pass 2w members and merged
+ * into a neighbour below w/2, so the x-fast trie holds O(n/w) keys
+ * and the whole set takes O(n) memory. Queries and updates are
+ * O(log w), amortized and expected for updates.
+ */
+type YFastTrie struct {
+
+    // The number of bits in a key, the universe is 2^w.
+    w uint
+
+    // The number of members in the set.
+    count uint64
+
+    // The representatives, and their buckets.
+    reps *XFastTrie
+    buckets map[uint64]*treap
+}
+
+func BuildYFastTrie(numBits uint64) *YFastTrie {
+    result := YFastTrie{}
+    result.reps = BuildXFastTrie(numBits)
+    result.w = result.reps.w
+    result.buckets = make(map[uint64]*treap)
+    return &result
+}
+
+// Buckets outside of these sizes are split or merged.
+func (trie *YFastTrie) minBucket() uint64 {
+    return max(uint64(trie.w) / 2, 1)
+}
+
+func (trie *YFastTrie) maxBucket() uint64 {
+    return 2 * uint64(trie.w)
+}
+
+/**
+ * Returns the representative of the bucket that x belongs in, and
+ * false if the trie is empty.
+ */
+func (trie *YFastTrie) bucketOf(x uint64) (uint64, bool) {
+    leaf := trie.reps.ceiling(x)
+    if leaf == nil {
+        return 0, false
+    }
+    return leaf.key, true
+}
+
+func (trie *YFastTrie) Min() uint64 {
+    min, ok := trie.MinOk()
+    if !ok {
+        panic(bvtree.ErrEmpty)
+    }
+    return min
+}
+
+func (trie *YFastTrie) MinOk() (uint64, bool) {
+    if trie.count == 0 {
+        return 0, false
+    }
+    return trie.buckets[trie.reps.head.key].min(), true
+}
+
+func (trie *YFastTrie) Max() uint64 {
+    max, ok := trie.MaxOk()
+    if !ok {
+        panic(bvtree.ErrEmpty)
+    }
+    return max
+}
+
+func (trie *YFastTrie) MaxOk() (uint64, bool) {
+    if trie.count == 0 {
+        return 0, false
+    }
+    return trie.buckets[trie.reps.tail.key].max(), true
+}
+
+/**
+ * Returns the number below n in the trie.
+ * Assumes that the number passed in is greater than
+ * the min value.
+ */
+func (trie *YFastTrie) Predecessor(n uint64) uint64 {
+    pred, ok := trie.PredecessorOk(n)
+    if !ok {
+        panic("There was a problem with predecessor.")
+    }
+    return pred
+}
+
+/**
+ * Returns the number below n in the trie, and false if
+ * there is no such number.
+ */
+func (trie *YFastTrie) PredecessorOk(n uint64) (uint64, bool) {
+    // Everything in the trie is below n.
+    if !trie.reps.inUniverse(n) {
+        return trie.MaxOk()
+    }
+    rep, ok := trie.bucketOf(n)
+    if !ok {
+        return 0, false
+    }
+    if pred, ok := trie.buckets[rep].predecessor(n); ok {
+        return pred, true
+    }
+
+    // Otherwise it's the max of the bucket before.
+    prev, ok := trie.reps.PredecessorOk(rep)
+    if !ok {
+        return 0, false
+    }
+    return trie.buckets[prev].max(), true
+}
+
+/**
+ * Returns the number above n in the trie.
+ * Assumes that the number passed in is less than
+ * the max value.
+ */
+func (trie *YFastTrie) Successor(n uint64) uint64 {
+    succ, ok := trie.SuccessorOk(n)
+    if !ok {
+        panic("There was a problem with successor.")
+    }
+    return succ
+}
+
+/**
+ * Returns the number above n in the trie, and false if
+ * there is no such number.
+ */
+func (trie *YFastTrie) SuccessorOk(n uint64) (uint64, bool) {
+    if !trie.reps.inUniverse(n) {
+        return 0, false
+    }
+    rep, ok := trie.bucketOf(n)
+    if !ok {
+        return 0, false
+    }
+    if succ, ok := trie.buckets[rep].successor(n); ok {
+        return succ, true
+    }
+
+    // Otherwise it's the min of the bucket after.
+    next, ok := trie.reps.SuccessorOk(rep)
+    if !ok {
+        return 0, false
+    }
+    return trie.buckets[next].min(), true
+}
+
+/**
+ * returns true if the trie contains the given uint64.
+ */
+func (trie *YFastTrie) Contains(n uint64) bool {
+    if !trie.reps.inUniverse(n) {
+        return false
+    }
+    rep, ok := trie.bucketOf(n)
+    return ok && trie.buckets[rep].contains(n)
+}
+
+/**
+ * Inserts the integer n into the trie, returns true if
+ * it wasn't already there.
+ */
+func (trie *YFastTrie) Insert(n uint64) bool {
+    added, err := trie.InsertChecked(n)
+    if err != nil {
+        panic(err)
+    }
+    return added
+}
+
+/**
+ * Removes the integer n from the trie, returns true if
+ * it was there.
+ */
+func (trie *YFastTrie) Remove(n uint64) bool {
+    if !trie.reps.inUniverse(n) {
+        return false
+    }
+    rep, ok := trie.bucketOf(n)
+    if !ok || !trie.buckets[rep].remove(n) {
+        return false
+    }
+    trie.count--
+    trie.rebalance(rep)
+    return true
+}
+
+/**
+ * returns true if the trie contains n, or an error if n
+ * is outside of the universe.
+ */
+func (trie *YFastTrie) ContainsChecked(n uint64) (bool, error) {
+    if err := bvtree.CheckUniverse(n, trie.Universe()); err != nil {
+        return false, err
+    }
+    return trie.Contains(n), nil
+}
+
+/**
+ * Inserts n, returning an error instead of panicking if n
+ * is outside of the universe.
+ */
+func (trie *YFastTrie) InsertChecked(n uint64) (bool, error) {
+    if err := bvtree.CheckUniverse(n, trie.Universe()); err != nil {
+        return false, err
+    }
+
+    rep, ok := trie.bucketOf(n)
+    if !ok {
+        // The first bucket covers the whole universe.
+        rep = trie.Universe() - 1
+        trie.reps.insert(rep)
+        trie.buckets[rep] = &treap{}
+    }
+    if !trie.buckets[rep].insert(n) {
+        return false, nil
+    }
+    trie.count++
+    trie.rebalance(rep)
+    return true, nil
+}
+
+/**
+ * Removes n, returning an error if n is outside of the universe.
+ */
+func (trie *YFastTrie) RemoveChecked(n uint64) (bool, error) {
+    if err := bvtree.CheckUniverse(n, trie.Universe()); err != nil {
+        return false, err
+    }
+    return trie.Remove(n), nil
+}
+
+/**
+ * Splits the bucket of rep if it's grown too big, or merges it into
+ * a neighbour if it's shrunk too small.
+ */
+func (trie *YFastTrie) rebalance(rep uint64) {
+    bucket := trie.buckets[rep]
+    switch {
+    case bucket.size > trie.maxBucket():
+        trie.split(rep)
+
+    case trie.count == 0:
+        // Drop the last bucket, so an empty trie holds nothing.
+        trie.reps.Remove(rep)
+        delete(trie.buckets, rep)
+
+    case bucket.size < trie.minBucket() && trie.reps.Len() > 1:
+        // Merge into the bucket above, the last bucket has to keep its
+        // representative so it takes the one below instead.
+        if next, ok := trie.reps.SuccessorOk(rep); ok {
+            trie.merge(rep, next)
+        } else {
+            trie.merge(trie.reps.Predecessor(rep), rep)
+        }
+    }
+}
+
+/**
+ * Moves the lower half of the bucket of rep into a new bucket,
+ * represented by its max.
+ */
+func (trie *YFastTrie) split(rep uint64) {
+    keys := trie.buckets[rep].appendKeys(nil)
+    half := len(keys) / 2
+
+    lower, upper := &treap{}, &treap{}
+    for _, key := range(keys[:half]) {
+        lower.insert(key)
+    }
+    for _, key := range(keys[half:]) {
+        upper.insert(key)
+    }
+    trie.buckets[rep] = upper
+    trie.buckets[keys[half - 1]] = lower
+    trie.reps.insert(keys[half - 1])
+}
+
+/**
+ * Moves the bucket of lowRep into the bucket above it, highRep,
+ * splitting the result again if it's too big.
+ */
+func (trie *YFastTrie) merge(lowRep uint64, highRep uint64) {
+    high := trie.buckets[highRep]
+    for _, key := range(trie.buckets[lowRep].appendKeys(nil)) {
+        high.insert(key)
+    }
+    trie.reps.Remove(lowRep)
+    delete(trie.buckets, lowRep)
+
+    if high.size > trie.maxBucket() {
+        trie.split(highRep)
+    }
+}
+
+/**
+ * Returns the number of members in the trie.
+ */
+func (trie *YFastTrie) Len() uint64 {
+    return trie.count
+}
+
+/**
+ * Returns an iterator over the members in ascending order.
+ */
+func (trie *YFastTrie) All() iter.Seq[uint64] {
+    return bvtree.Ascend(trie, 0, trie.Universe() - 1)
+}
+
+/**
+ * Returns an iterator over the members in descending order.
+ */
+func (trie *YFastTrie) Backward() iter.Seq[uint64] {
+    return bvtree.Descend(trie, 0, trie.Universe() - 1)
+}
+
+/**
+ * Returns an iterator over the members in [lo, hi), in
+ * ascending order.
+ */
+func (trie *YFastTrie) Range(lo uint64, hi uint64) iter.Seq[uint64] {
+    if hi == 0 {
+        return func(yield func(uint64) bool) {}
+    }
+    return bvtree.Ascend(trie, lo, hi - 1)
+}
+
+/**
+ * Returns the size of the universe, 2^w. A trie over all
+ * 64 bit keys returns 0.
+ */
+func (trie *YFastTrie) Universe() uint64 {
+    return uint64(1) << trie.w
+}
+
+func (trie *YFastTrie) DbgPrint() {
+    fmt.Println("DbgPrint: ")
+    for leaf := trie.reps.head; leaf != nil; leaf = leaf.right {
+        fmt.Printf("bucket %d: %v\n", leaf.key, trie.buckets[leaf.key].appendKeys(nil))
+    }
+}
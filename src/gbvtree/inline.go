@@ -0,0 +1,281 @@
+package gbvtree
+
+import (
+    "iter"
+
+    "../bvtree"
+)
+
+/**
+ * BvFhTree8 and BvFhTree16 are BvFhTrees over universes of 2^8 and
+ * 2^16 keys, with the summary, the bitvector and the cluster counts
+ * in fixed size arrays inside the struct rather than in slices. The
+ * zero value is an empty set ready to use, and a set is a single
+ * allocation, or none at all on the stack.
+ *
+ * Any key type fits, keys past the universe are outside of it the
+ * same as for any other set.
+ */
+type BvFhTree8[K Key] struct {
+    inlineSet[K, fhStore8, *fhStore8, bvtree.FhWords]
+}
+
+type BvFhTree16[K Key] struct {
+    inlineSet[K, fhStore16, *fhStore16, bvtree.FhWords]
+}
+
+/**
+ * BvTree8 and BvTree16 are the same for BvTrees, with the supporting
+ * tree and the bitvector in arrays. They have no Rank or Select, so
+ * they don't keep block counts.
+ */
+type BvTree8[K Key] struct {
+    inlineSet[K, bvStore8, *bvStore8, bvtree.BvWords]
+}
+
+type BvTree16[K Key] struct {
+    inlineSet[K, bvStore16, *bvStore16, bvtree.BvWords]
+}
+
+// 16 clusters of 16 keys.
+type fhStore8 struct {
+    clusterCounts [16]uint64
+    summary [1]uint64
+    bitvector [4]uint64
+}
+
+func (store *fhStore8) view() bvtree.FhWords {
+    return bvtree.FhWords{SqNumBits: 16, ClusterCounts: store.clusterCounts[:], Summary: store.summary[:], Bitvector: store.bitvector[:]}
+}
+
+// 256 clusters of 256 keys.
+type fhStore16 struct {
+    clusterCounts [256]uint64
+    summary [4]uint64
+    bitvector [1024]uint64
+}
+
+func (store *fhStore16) view() bvtree.FhWords {
+    return bvtree.FhWords{SqNumBits: 256, ClusterCounts: store.clusterCounts[:], Summary: store.summary[:], Bitvector: store.bitvector[:]}
+}
+
+type bvStore8 struct {
+    suptree [4]uint64
+    bitvector [4]uint64
+}
+
+func (store *bvStore8) view() bvtree.BvWords {
+    return bvtree.BvWords{Suptree: store.suptree[:], Bitvector: store.bitvector[:]}
+}
+
+type bvStore16 struct {
+    suptree [1024]uint64
+    bitvector [1024]uint64
+}
+
+func (store *bvStore16) view() bvtree.BvWords {
+    return bvtree.BvWords{Suptree: store.suptree[:], Bitvector: store.bitvector[:]}
+}
+
+/**
+ * The methods inlineSet needs from a layout, which bvtree.FhWords
+ * and bvtree.BvWords both have.
+ */
+type words interface {
+    NumBits() uint64
+    Contains(n uint64) bool
+    Insert(n uint64) bool
+    Remove(n uint64) bool
+    MinOk() (uint64, bool)
+    MaxOk() (uint64, bool)
+    SuccessorOk(n uint64) (uint64, bool)
+    PredecessorOk(n uint64) (uint64, bool)
+    DbgPrint()
+}
+
+type storage[S any, V words] interface {
+    *S
+    view() V
+}
+
+/**
+ * inlineSet holds its arrays in S, and runs every operation on the
+ * layout V over them, the same code as the bvtree set V belongs to.
+ */
+type inlineSet[K Key, S any, PS storage[S, V], V words] struct {
+    count uint32
+    store S
+}
+
+func (set *inlineSet[K, S, PS, V]) view() V {
+    return PS(&set.store).view()
+}
+
+/**
+ * Returns the size of the arrays' universe, capped to the keys K
+ * can hold.
+ */
+func (set *inlineSet[K, S, PS, V]) Universe() uint64 {
+    return capUniverse[K](set.view().NumBits())
+}
+
+func (set *inlineSet[K, S, PS, V]) Len() uint64 {
+    return uint64(set.count)
+}
+
+func (set *inlineSet[K, S, PS, V]) Contains(n K) bool {
+    view := set.view()
+    return uint64(n) < view.NumBits() && view.Contains(uint64(n))
+}
+
+func (set *inlineSet[K, S, PS, V]) Insert(n K) bool {
+    view := set.view()
+    if err := bvtree.CheckUniverse(uint64(n), view.NumBits()); err != nil {
+        panic(err)
+    }
+    if !view.Insert(uint64(n)) {
+        return false
+    }
+    set.count++
+    return true
+}
+
+func (set *inlineSet[K, S, PS, V]) Remove(n K) bool {
+    view := set.view()
+    if uint64(n) >= view.NumBits() || !view.Remove(uint64(n)) {
+        return false
+    }
+    set.count--
+    return true
+}
+
+func (set *inlineSet[K, S, PS, V]) ContainsChecked(n K) (bool, error) {
+    if err := bvtree.CheckUniverse(uint64(n), set.Universe()); err != nil {
+        return false, err
+    }
+    return set.Contains(n), nil
+}
+
+func (set *inlineSet[K, S, PS, V]) InsertChecked(n K) (bool, error) {
+    if err := bvtree.CheckUniverse(uint64(n), set.Universe()); err != nil {
+        return false, err
+    }
+    return set.Insert(n), nil
+}
+
+func (set *inlineSet[K, S, PS, V]) RemoveChecked(n K) (bool, error) {
+    if err := bvtree.CheckUniverse(uint64(n), set.Universe()); err != nil {
+        return false, err
+    }
+    return set.Remove(n), nil
+}
+
+func (set *inlineSet[K, S, PS, V]) Predecessor(n K) K {
+    pred, ok := set.PredecessorOk(n)
+    if !ok {
+        panic("There was a problem with predecessor.")
+    }
+    return pred
+}
+
+func (set *inlineSet[K, S, PS, V]) Successor(n K) K {
+    succ, ok := set.SuccessorOk(n)
+    if !ok {
+        panic("There was a problem with successor.")
+    }
+    return succ
+}
+
+func (set *inlineSet[K, S, PS, V]) Min() K {
+    min, ok := set.MinOk()
+    if !ok {
+        panic(bvtree.ErrEmpty)
+    }
+    return min
+}
+
+func (set *inlineSet[K, S, PS, V]) Max() K {
+    max, ok := set.MaxOk()
+    if !ok {
+        panic(bvtree.ErrEmpty)
+    }
+    return max
+}
+
+func (set *inlineSet[K, S, PS, V]) PredecessorOk(n K) (K, bool) {
+    pred, ok := set.view().PredecessorOk(uint64(n))
+    return K(pred), ok
+}
+
+func (set *inlineSet[K, S, PS, V]) SuccessorOk(n K) (K, bool) {
+    succ, ok := set.view().SuccessorOk(uint64(n))
+    return K(succ), ok
+}
+
+func (set *inlineSet[K, S, PS, V]) MinOk() (K, bool) {
+    min, ok := set.view().MinOk()
+    return K(min), ok
+}
+
+func (set *inlineSet[K, S, PS, V]) MaxOk() (K, bool) {
+    max, ok := set.view().MaxOk()
+    return K(max), ok
+}
+
+func (set *inlineSet[K, S, PS, V]) All() iter.Seq[K] {
+    return ascend[K](set.view(), 0, set.Universe())
+}
+
+func (set *inlineSet[K, S, PS, V]) Backward() iter.Seq[K] {
+    return descend[K](set.view(), 0, set.Universe())
+}
+
+/**
+ * Returns an iterator over the members in [lo, hi).
+ */
+func (set *inlineSet[K, S, PS, V]) Range(lo K, hi K) iter.Seq[K] {
+    return ascend[K](set.view(), uint64(lo), min(uint64(hi), set.Universe()))
+}
+
+func (set *inlineSet[K, S, PS, V]) DbgPrint() {
+    set.view().DbgPrint()
+}
+
+/**
+ * Returns an iterator over the members in [lo, hi), in ascending
+ * order. The cursor lives inside the closure, so the iterator can be
+ * ranged over more than once.
+ */
+func ascend[K Key, V words](view V, lo uint64, hi uint64) iter.Seq[K] {
+    return func(yield func(K) bool) {
+        if lo >= hi {
+            return
+        }
+        n, ok := lo, view.Contains(lo)
+        if !ok {
+            n, ok = view.SuccessorOk(lo)
+        }
+        for ok && n < hi {
+            if !yield(K(n)) {
+                return
+            }
+            n, ok = view.SuccessorOk(n)
+        }
+    }
+}
+
+/**
+ * Returns an iterator over the members in [lo, hi), in descending
+ * order.
+ */
+func descend[K Key, V words](view V, lo uint64, hi uint64) iter.Seq[K] {
+    return func(yield func(K) bool) {
+        n, ok := view.PredecessorOk(hi)
+        for ok && n >= lo {
+            if !yield(K(n)) {
+                return
+            }
+            n, ok = view.PredecessorOk(n)
+        }
+    }
+}
@@ -0,0 +1,53 @@
+package gbvtree
+
+import (
+    "iter"
+)
+
+/**
+ * Key is any unsigned integer type a set can hold.
+ */
+type Key interface {
+    ~uint8 | ~uint16 | ~uint32 | ~uint64
+}
+
+/**
+ * DynamicSet is bvtree.DynamicSet over keys of type K, with the
+ * same semantics. Universe and Len stay uint64, a set over all
+ * the keys of a uint8 has a universe of 256.
+ */
+type DynamicSet[K Key] interface {
+
+    // Keys run from 0 to Universe() - 1, a set over all 2^64
+    // keys returns 0.
+    Universe() uint64
+
+    // The number of members, in O(1).
+    Len() uint64
+
+    Contains(n K) bool
+    Insert(n K) bool
+    Remove(n K) bool
+
+    ContainsChecked(n K) (bool, error)
+    InsertChecked(n K) (bool, error)
+    RemoveChecked(n K) (bool, error)
+
+    Predecessor(n K) K
+    Successor(n K) K
+
+    Min() K
+    Max() K
+
+    PredecessorOk(n K) (K, bool)
+    SuccessorOk(n K) (K, bool)
+
+    MinOk() (K, bool)
+    MaxOk() (K, bool)
+
+    All() iter.Seq[K]
+    Backward() iter.Seq[K]
+    Range(lo K, hi K) iter.Seq[K]
+
+    DbgPrint()
+}
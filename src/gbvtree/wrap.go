@@ -0,0 +1,179 @@
+package gbvtree
+
+import (
+    "iter"
+    "unsafe"
+
+    "../bvtree"
+)
+
+/**
+ * Wrapped is a DynamicSet[K] over a bvtree.DynamicSet, converting
+ * keys on the way in and out. It's how keys wider than 16 bits are
+ * handled, the wrapped set does all the work.
+ */
+type Wrapped[K Key] struct {
+    set bvtree.DynamicSet
+}
+
+/**
+ * Wraps set as a set of K. Keys that don't fit in a K can't be
+ * added through the wrapper, and mustn't be added to set directly.
+ */
+func Wrap[K Key](set bvtree.DynamicSet) *Wrapped[K] {
+    return &Wrapped[K]{set}
+}
+
+/**
+ * Returns the wrapped set.
+ */
+func (wrapped *Wrapped[K]) Unwrap() bvtree.DynamicSet {
+    return wrapped.set
+}
+
+/**
+ * Builds a set of K over universe keys, using inline arrays when
+ * the universe fits in 2^8 or 2^16 keys and a bvtree.BvFhTree
+ * otherwise. The universe is also capped to the keys K can hold.
+ */
+func NewBvFhTree[K Key](universe uint64) DynamicSet[K] {
+    universe = capUniverse[K](universe)
+    switch {
+    case universe != 0 && universe <= 1 << 8:
+        return &BvFhTree8[K]{}
+    case universe != 0 && universe <= 1 << 16:
+        return &BvFhTree16[K]{}
+    }
+    return Wrap[K](bvtree.BuildBvFhTree(universe))
+}
+
+/**
+ * Builds a set of K over universe keys, using inline arrays when
+ * the universe fits in 2^8 or 2^16 keys and a bvtree.BvTree
+ * otherwise, the same as NewBvFhTree.
+ */
+func NewBvTree[K Key](universe uint64) DynamicSet[K] {
+    universe = capUniverse[K](universe)
+    switch {
+    case universe != 0 && universe <= 1 << 8:
+        return &BvTree8[K]{}
+    case universe != 0 && universe <= 1 << 16:
+        return &BvTree16[K]{}
+    }
+    return Wrap[K](bvtree.BuildBvTree(universe))
+}
+
+/**
+ * Returns the smaller of universe and the number of keys K can
+ * hold, 0 standing for 2^64 as usual.
+ */
+func capUniverse[K Key](universe uint64) uint64 {
+    width := uint(unsafe.Sizeof(K(0))) * 8
+    if width == 64 {
+        return universe
+    }
+    if universe == 0 || universe > 1 << width {
+        return 1 << width
+    }
+    return universe
+}
+
+/**
+ * The universe of the wrapped set, capped to the keys K can hold.
+ */
+func (wrapped *Wrapped[K]) Universe() uint64 {
+    return capUniverse[K](wrapped.set.Universe())
+}
+
+func (wrapped *Wrapped[K]) Len() uint64 {
+    return wrapped.set.Len()
+}
+
+func (wrapped *Wrapped[K]) Contains(n K) bool {
+    return wrapped.set.Contains(uint64(n))
+}
+
+func (wrapped *Wrapped[K]) Insert(n K) bool {
+    return wrapped.set.Insert(uint64(n))
+}
+
+func (wrapped *Wrapped[K]) Remove(n K) bool {
+    return wrapped.set.Remove(uint64(n))
+}
+
+func (wrapped *Wrapped[K]) ContainsChecked(n K) (bool, error) {
+    return wrapped.set.ContainsChecked(uint64(n))
+}
+
+func (wrapped *Wrapped[K]) InsertChecked(n K) (bool, error) {
+    return wrapped.set.InsertChecked(uint64(n))
+}
+
+func (wrapped *Wrapped[K]) RemoveChecked(n K) (bool, error) {
+    return wrapped.set.RemoveChecked(uint64(n))
+}
+
+func (wrapped *Wrapped[K]) Predecessor(n K) K {
+    return K(wrapped.set.Predecessor(uint64(n)))
+}
+
+func (wrapped *Wrapped[K]) Successor(n K) K {
+    return K(wrapped.set.Successor(uint64(n)))
+}
+
+func (wrapped *Wrapped[K]) Min() K {
+    return K(wrapped.set.Min())
+}
+
+func (wrapped *Wrapped[K]) Max() K {
+    return K(wrapped.set.Max())
+}
+
+func (wrapped *Wrapped[K]) PredecessorOk(n K) (K, bool) {
+    pred, ok := wrapped.set.PredecessorOk(uint64(n))
+    return K(pred), ok
+}
+
+func (wrapped *Wrapped[K]) SuccessorOk(n K) (K, bool) {
+    succ, ok := wrapped.set.SuccessorOk(uint64(n))
+    return K(succ), ok
+}
+
+func (wrapped *Wrapped[K]) MinOk() (K, bool) {
+    min, ok := wrapped.set.MinOk()
+    return K(min), ok
+}
+
+func (wrapped *Wrapped[K]) MaxOk() (K, bool) {
+    max, ok := wrapped.set.MaxOk()
+    return K(max), ok
+}
+
+func (wrapped *Wrapped[K]) All() iter.Seq[K] {
+    return convert[K](wrapped.set.All())
+}
+
+func (wrapped *Wrapped[K]) Backward() iter.Seq[K] {
+    return convert[K](wrapped.set.Backward())
+}
+
+func (wrapped *Wrapped[K]) Range(lo K, hi K) iter.Seq[K] {
+    return convert[K](wrapped.set.Range(uint64(lo), uint64(hi)))
+}
+
+func (wrapped *Wrapped[K]) DbgPrint() {
+    wrapped.set.DbgPrint()
+}
+
+/**
+ * Converts the members of seq to K.
+ */
+func convert[K Key](seq iter.Seq[uint64]) iter.Seq[K] {
+    return func(yield func(K) bool) {
+        for n := range(seq) {
+            if !yield(K(n)) {
+                return
+            }
+        }
+    }
+}
@@ -0,0 +1,435 @@
+package pvebtree
+
+import (
+    "../bvtree"
+    "fmt"
+    "iter"
+)
+
+/**
+ * PvEBTree is a proto van Emde Boas tree (CLRS 20.2) holding
+ * a set of integers between 0 and n.
+ *
+ * The universe is rounded up to the next size of the form 2^(2^k),
+ * so that every level splits evenly into sqrt(u) clusters of
+ * size sqrt(u), plus a summary structure of size sqrt(u).
+ */
+type PvEBTree struct {
+
+    // The size of the universe, always of the form 2^(2^k).
+    numBits uint64
+
+    root *protoNode
+}
+
+/**
+ * protoNode is one level of the recursive proto-vEB structure.
+ */
+type protoNode struct {
+
+    // The size of the universe this node covers.
+    u uint64
+
+    // The number of members below this node. CLRS leaves this as
+    // exercise 20.2-2, we need it so that Remove knows when to clear
+    // the summary bit of a cluster.
+    n uint64
+
+    // Base case (u == 2), the two bits of the universe.
+    a [2]bool
+
+    // The summary of which clusters are non empty.
+    summary *protoNode
+
+    // The sqrt(u) clusters, each of size sqrt(u).
+    cluster []*protoNode
+}
+
+func (pTree *PvEBTree) Min() uint64 {
+    min, ok := pTree.MinOk()
+    if !ok {
+        panic(bvtree.ErrEmpty)
+    }
+    return min
+}
+
+func (pTree *PvEBTree) MinOk() (uint64, bool) {
+    return pTree.root.min()
+}
+
+func (pTree *PvEBTree) Max() uint64 {
+    max, ok := pTree.MaxOk()
+    if !ok {
+        panic(bvtree.ErrEmpty)
+    }
+    return max
+}
+
+func (pTree *PvEBTree) MaxOk() (uint64, bool) {
+    return pTree.root.max()
+}
+
+/**
+ * Returns the number below n in the tree.
+ * Assumes that the number passed in is greater than
+ * the min value.
+ */
+func (pTree *PvEBTree) Predecessor(n uint64) uint64 {
+    pred, ok := pTree.PredecessorOk(n)
+    if !ok {
+        panic("There was a problem with predecessor.")
+    }
+    return pred
+}
+
+/**
+ * Returns the number below n in the tree, and false if
+ * there is no such number.
+ */
+func (pTree *PvEBTree) PredecessorOk(n uint64) (uint64, bool) {
+    // Everything in the tree is below n.
+    if n >= pTree.numBits {
+        return pTree.root.max()
+    }
+    return pTree.root.predecessor(n)
+}
+
+/**
+ * Returns the number above n in the tree.
+ * Assumes that the number passed in is less than
+ * the max value.
+ */
+func (pTree *PvEBTree) Successor(n uint64) uint64 {
+    succ, ok := pTree.SuccessorOk(n)
+    if !ok {
+        panic("There was a problem with successor.")
+    }
+    return succ
+}
+
+/**
+ * Returns the number above n in the tree, and false if
+ * there is no such number.
+ */
+func (pTree *PvEBTree) SuccessorOk(n uint64) (uint64, bool) {
+    if n >= pTree.numBits {
+        return 0, false
+    }
+    return pTree.root.successor(n)
+}
+
+/**
+ * returns true if the tree contains the given uint64.
+ */
+func (pTree *PvEBTree) Contains(n uint64) bool {
+    if n >= pTree.numBits {
+        return false
+    }
+    return pTree.root.member(n)
+}
+
+/**
+ * Inserts the integer n into the tree, returns true if
+ * it wasn't already there.
+ */
+func (pTree *PvEBTree) Insert(n uint64) bool {
+    if err := bvtree.CheckUniverse(n, pTree.numBits); err != nil {
+        panic(err)
+    }
+    return pTree.root.insert(n)
+}
+
+/**
+ * Removes the integer n from the tree, returns true if
+ * it was there.
+ */
+func (pTree *PvEBTree) Remove(n uint64) bool {
+    if n >= pTree.numBits {
+        return false
+    }
+    return pTree.root.remove(n)
+}
+
+/**
+ * returns true if the tree contains n, or an error if n
+ * is outside of the universe.
+ */
+func (pTree *PvEBTree) ContainsChecked(n uint64) (bool, error) {
+    if err := bvtree.CheckUniverse(n, pTree.numBits); err != nil {
+        return false, err
+    }
+    return pTree.root.member(n), nil
+}
+
+/**
+ * Inserts n, returning an error instead of panicking if n
+ * is outside of the universe.
+ */
+func (pTree *PvEBTree) InsertChecked(n uint64) (bool, error) {
+    if err := bvtree.CheckUniverse(n, pTree.numBits); err != nil {
+        return false, err
+    }
+    return pTree.root.insert(n), nil
+}
+
+/**
+ * Removes n, returning an error if n is outside of the universe.
+ */
+func (pTree *PvEBTree) RemoveChecked(n uint64) (bool, error) {
+    if err := bvtree.CheckUniverse(n, pTree.numBits); err != nil {
+        return false, err
+    }
+    return pTree.root.remove(n), nil
+}
+
+/**
+ * Returns the number of members in the tree.
+ */
+func (pTree *PvEBTree) Len() uint64 {
+    return pTree.root.n
+}
+
+/**
+ * Returns an iterator over the members in ascending order.
+ */
+func (pTree *PvEBTree) All() iter.Seq[uint64] {
+    return bvtree.Ascend(pTree, 0, pTree.numBits - 1)
+}
+
+/**
+ * Returns an iterator over the members in descending order.
+ */
+func (pTree *PvEBTree) Backward() iter.Seq[uint64] {
+    return bvtree.Descend(pTree, 0, pTree.numBits - 1)
+}
+
+/**
+ * Returns an iterator over the members in [lo, hi), in
+ * ascending order.
+ */
+func (pTree *PvEBTree) Range(lo uint64, hi uint64) iter.Seq[uint64] {
+    if hi == 0 {
+        return func(yield func(uint64) bool) {}
+    }
+    return bvtree.Ascend(pTree, lo, hi - 1)
+}
+
+/**
+ * Returns the size of the universe, after rounding up to 2^(2^k).
+ */
+func (pTree *PvEBTree) Universe() uint64 {
+    return pTree.numBits
+}
+
+/**
+ * Returns the smallest size of the form 2^(2^k) that
+ * holds at least numBits values.
+ */
+func getPvEBUniverse(numBits uint64) uint64 {
+    // The proto structure is fully allocated, and the next size
+    // after 2^32 doesn't fit in a uint64 anyway.
+    if numBits > uint64(1 << 32) {
+        panic("Universe too large for a proto-vEB tree.")
+    }
+
+    result := uint64(2)
+    for result < numBits {
+        result *= result
+    }
+    return result
+}
+
+func BuildPvEBTree(numBits uint64) *PvEBTree {
+    result := PvEBTree{}
+    result.numBits = getPvEBUniverse(numBits)
+    result.root = buildProtoNode(result.numBits)
+    return &result
+}
+
+func buildProtoNode(u uint64) *protoNode {
+    node := protoNode{u: u}
+    if u == 2 {
+        return &node
+    }
+
+    sq := node.sqrt()
+    node.summary = buildProtoNode(sq)
+    node.cluster = make([]*protoNode, sq)
+    for i := range(node.cluster) {
+        node.cluster[i] = buildProtoNode(sq)
+    }
+    return &node
+}
+
+// The size of each cluster, and the number of clusters.
+func (node *protoNode) sqrt() uint64 {
+    result := uint64(2)
+    for result * result < node.u {
+        result *= result
+    }
+    return result
+}
+
+func (node *protoNode) high(x uint64) uint64 {
+    return x / node.sqrt()
+}
+
+func (node *protoNode) low(x uint64) uint64 {
+    return x % node.sqrt()
+}
+
+func (node *protoNode) index(x uint64, y uint64) uint64 {
+    return x * node.sqrt() + y
+}
+
+func (node *protoNode) member(x uint64) bool {
+    if node.u == 2 {
+        return node.a[x]
+    }
+    return node.cluster[node.high(x)].member(node.low(x))
+}
+
+func (node *protoNode) min() (uint64, bool) {
+    if node.u == 2 {
+        if node.a[0] {
+            return 0, true
+        } else if node.a[1] {
+            return 1, true
+        }
+        return 0, false
+    }
+
+    minCluster, ok := node.summary.min()
+    if !ok {
+        return 0, false
+    }
+    offset, _ := node.cluster[minCluster].min()
+    return node.index(minCluster, offset), true
+}
+
+func (node *protoNode) max() (uint64, bool) {
+    if node.u == 2 {
+        if node.a[1] {
+            return 1, true
+        } else if node.a[0] {
+            return 0, true
+        }
+        return 0, false
+    }
+
+    maxCluster, ok := node.summary.max()
+    if !ok {
+        return 0, false
+    }
+    offset, _ := node.cluster[maxCluster].max()
+    return node.index(maxCluster, offset), true
+}
+
+func (node *protoNode) successor(x uint64) (uint64, bool) {
+    if node.u == 2 {
+        if x == 0 && node.a[1] {
+            return 1, true
+        }
+        return 0, false
+    }
+
+    // First look inside x's own cluster.
+    high := node.high(x)
+    offset, ok := node.cluster[high].successor(node.low(x))
+    if ok {
+        return node.index(high, offset), true
+    }
+
+    // Otherwise the answer is the min of the next non empty cluster.
+    succCluster, ok := node.summary.successor(high)
+    if !ok {
+        return 0, false
+    }
+    offset, _ = node.cluster[succCluster].min()
+    return node.index(succCluster, offset), true
+}
+
+func (node *protoNode) predecessor(x uint64) (uint64, bool) {
+    if node.u == 2 {
+        if x == 1 && node.a[0] {
+            return 0, true
+        }
+        return 0, false
+    }
+
+    // First look inside x's own cluster.
+    high := node.high(x)
+    offset, ok := node.cluster[high].predecessor(node.low(x))
+    if ok {
+        return node.index(high, offset), true
+    }
+
+    // Otherwise the answer is the max of the previous non empty cluster.
+    predCluster, ok := node.summary.predecessor(high)
+    if !ok {
+        return 0, false
+    }
+    offset, _ = node.cluster[predCluster].max()
+    return node.index(predCluster, offset), true
+}
+
+/**
+ * Inserts x below this node, returns true if x wasn't
+ * already a member.
+ */
+func (node *protoNode) insert(x uint64) bool {
+    if node.u == 2 {
+        if node.a[x] {
+            return false
+        }
+        node.a[x] = true
+        node.n++
+        return true
+    }
+
+    high := node.high(x)
+    if !node.cluster[high].insert(node.low(x)) {
+        return false
+    }
+    node.summary.insert(high)
+    node.n++
+    return true
+}
+
+/**
+ * Removes x from below this node, returns true if x
+ * was a member.
+ */
+func (node *protoNode) remove(x uint64) bool {
+    if node.u == 2 {
+        if !node.a[x] {
+            return false
+        }
+        node.a[x] = false
+        node.n--
+        return true
+    }
+
+    high := node.high(x)
+    if !node.cluster[high].remove(node.low(x)) {
+        return false
+    }
+
+    // Only clear the summary once the whole cluster is gone.
+    if node.cluster[high].n == 0 {
+        node.summary.remove(high)
+    }
+    node.n--
+    return true
+}
+
+func (pTree *PvEBTree) DbgPrint() {
+    fmt.Println("DbgPrint: ")
+    fmt.Printf("universe %d, %d members\n", pTree.numBits, pTree.root.n)
+    cur, ok := pTree.root.min()
+    for ok {
+        fmt.Printf("%d ", cur)
+        cur, ok = pTree.root.successor(cur)
+    }
+    fmt.Println(" ")
+}
@@ -1,26 +1,88 @@
 package main
 
 import (
+    "errors"
     "fmt"
+    "io"
+    "iter"
+    "log/slog"
     "./bvtree"
+    "./fasttrie"
+    "./gbvtree"
+    "./pvebtree"
+    "./vebtree"
+    "bytes"
+    "math"
+    "math/bits"
+    "maps"
     "math/rand"
+    "os"
+    "slices"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "testing"
     "time"
 )
 
 func main() {
     rand.Seed(time.Now().UTC().UnixNano())
+    if len(os.Args) > 1 && os.Args[1] == "bench" {
+        benchmain()
+        return
+    }
+
     numBits := uint64(14336)
     numToInsert := 20
+    for _, build := range(builders) {
+        randomCheck(build, numBits, numToInsert)
+    }
+    vebmain()
+    algebramain()
+    encodingmain()
+    compressmain()
+    roaringmain()
+    mmapmain()
+    syncmain()
+    concurrentmain()
+    shardedmain()
+    persistentmain()
+    clonemain()
+    optionsmain()
+    genericmain()
+    keyedmain()
+    rsvebmain()
+    fasttriemain()
+    frozenmain()
+}
+
+// Builders for every DynamicSet implementation that checkTree exercises.
+var builders = []func(uint64) bvtree.DynamicSet{
+    func(numBits uint64) bvtree.DynamicSet { return bvtree.BuildBvTree(numBits) },
+    func(numBits uint64) bvtree.DynamicSet { return bvtree.BuildBvFhTree(numBits) },
+    func(numBits uint64) bvtree.DynamicSet { return bvtree.BuildBvMlTree(numBits) },
+    func(numBits uint64) bvtree.DynamicSet { return pvebtree.BuildPvEBTree(numBits) },
+    func(numBits uint64) bvtree.DynamicSet { return vebtree.BuildVEBTree(numBits) },
+    func(numBits uint64) bvtree.DynamicSet { return vebtree.BuildRSVEBTree(numBits) },
+    func(numBits uint64) bvtree.DynamicSet { return fasttrie.BuildXFastTrie(numBits) },
+    func(numBits uint64) bvtree.DynamicSet { return fasttrie.BuildYFastTrie(numBits) },
+    func(numBits uint64) bvtree.DynamicSet { return bvtree.BuildConcurrentBvFhTree(numBits) },
+    func(numBits uint64) bvtree.DynamicSet { return bvtree.BuildShardedSet(numBits, 8) },
+}
+
+func randomCheck(build func(uint64) bvtree.DynamicSet, numBits uint64, numToInsert int) {
     for i := 0; i < 10; i++ {
-        bvTree := bvtree.BuildBvFhTree(numBits)
+        bvTree := build(numBits)
         vals := make(map[uint64] bool, numToInsert)
         myMin := uint64(numBits)
         myMax := uint64(0)
         for j := 0; j < numToInsert; j++ {
             n := uint64(rand.Int63n(int64(numBits)))
             fmt.Println(n)
+            if bvTree.Insert(n) == vals[n] {
+                panic("Insert didn't report whether the value was new!")
+            }
             vals[n] = true
-            bvTree.Insert(n)
             if n < myMin {
                 myMin = n
             }
@@ -95,6 +157,1347 @@ func bvfhmain() {
 
 }
 
+func vebmain() {
+    fmt.Println("Sample of a vEB tree over the full 64 bit universe")
+    vTree := vebtree.BuildVEBTree(^uint64(0))
+    vals := make(map[uint64] bool, 1000)
+    myMin := ^uint64(0)
+    myMax := uint64(0)
+    for j := 0; j < 1000; j++ {
+        n := rand.Uint64()
+        if j % 2 == 0 {
+            // Keep some of the keys close together.
+            n = (n >> 40) + uint64(j)
+        }
+        vals[n] = true
+        vTree.Insert(n)
+        if n < myMin {
+            myMin = n
+        }
+        if n > myMax {
+            myMax = n
+        }
+    }
+    checkTree(vTree, myMin, myMax, vals, []uint64{})
+
+    // Empty it back out, the tree should drop all of its clusters.
+    for val, _ := range(vals) {
+        if !vTree.Remove(val) {
+            panic("Remove didn't find a value I put in!")
+        }
+        if vTree.Contains(val) {
+            panic("Removed value is still in the tree!")
+        }
+    }
+    if vTree.Len() != 0 {
+        panic("Tree isn't empty after removing everything!")
+    }
+}
+
+func algebramain() {
+    fmt.Println("Set algebra on BvFhTrees and BvTrees")
+    numBits := uint64(14336)
+    fhA, fhB := bvtree.BuildBvFhTree(numBits), bvtree.BuildBvFhTree(numBits)
+    bvA, bvB := bvtree.BuildBvTree(numBits), bvtree.BuildBvTree(numBits)
+    aVals := make(map[uint64] bool, 200)
+    bVals := make(map[uint64] bool, 200)
+    for j := 0; j < 200; j++ {
+        a, b := uint64(rand.Int63n(int64(numBits))), uint64(rand.Int63n(int64(numBits)))
+        aVals[a], bVals[b] = true, true
+        fhA.Insert(a)
+        fhB.Insert(b)
+        bvA.Insert(a)
+        bvB.Insert(b)
+    }
+
+    ops := []struct {
+        keep func(a bool, b bool) bool
+        fh func() (*bvtree.BvFhTree, error)
+        bv func() (*bvtree.BvTree, error)
+    }{
+        {func(a bool, b bool) bool { return a || b }, func() (*bvtree.BvFhTree, error) { return fhA.Union(fhB) }, func() (*bvtree.BvTree, error) { return bvA.Union(bvB) }},
+        {func(a bool, b bool) bool { return a && b }, func() (*bvtree.BvFhTree, error) { return fhA.Intersection(fhB) }, func() (*bvtree.BvTree, error) { return bvA.Intersection(bvB) }},
+        {func(a bool, b bool) bool { return a && !b }, func() (*bvtree.BvFhTree, error) { return fhA.Difference(fhB) }, func() (*bvtree.BvTree, error) { return bvA.Difference(bvB) }},
+        {func(a bool, b bool) bool { return a != b }, func() (*bvtree.BvFhTree, error) { return fhA.SymmetricDifference(fhB) }, func() (*bvtree.BvTree, error) { return bvA.SymmetricDifference(bvB) }},
+    }
+    for _, op := range(ops) {
+        vals := make(map[uint64] bool)
+        myMin, myMax := numBits, uint64(0)
+        for n := uint64(0); n < numBits; n++ {
+            if op.keep(aVals[n], bVals[n]) {
+                vals[n] = true
+                myMin, myMax = min(myMin, n), max(myMax, n)
+            }
+        }
+
+        fhTree, err := op.fh()
+        if err != nil {
+            panic(err)
+        }
+        bvTree, err := op.bv()
+        if err != nil {
+            panic(err)
+        }
+        if len(vals) > 0 {
+            checkTree(fhTree, myMin, myMax, vals, []uint64{})
+            checkTree(bvTree, myMin, myMax, vals, []uint64{})
+        }
+    }
+
+    // The in place versions modify the receiver.
+    if err := fhA.UnionWith(fhB); err != nil || fhA.Len() < fhB.Len() {
+        panic("UnionWith didn't add everything!")
+    }
+    if err := bvA.DifferenceWith(bvA); err != nil || bvA.Len() != 0 {
+        panic("DifferenceWith itself should be empty!")
+    }
+    if _, err := fhA.Union(bvtree.BuildBvFhTree(numBits * 4)); !errors.Is(err, bvtree.ErrUniverseMismatch) {
+        panic("Combined trees of different universes!")
+    }
+}
+
+func encodingmain() {
+    fmt.Println("Round trip BvFhTrees and BvTrees through MarshalBinary")
+    numBits := uint64(14336)
+    fhTree, bvTree := bvtree.BuildBvFhTree(numBits), bvtree.BuildBvTree(numBits)
+    vals := make(map[uint64] bool, 100)
+    myMin, myMax := numBits, uint64(0)
+    for j := 0; j < 100; j++ {
+        n := uint64(rand.Int63n(int64(numBits)))
+        vals[n] = true
+        myMin, myMax = min(myMin, n), max(myMax, n)
+        fhTree.Insert(n)
+        bvTree.Insert(n)
+    }
+
+    fhData, err := fhTree.MarshalBinary()
+    if err != nil {
+        panic(err)
+    }
+    var fhCopy bvtree.BvFhTree
+    if err := fhCopy.UnmarshalBinary(fhData); err != nil {
+        panic(err)
+    }
+    checkTree(&fhCopy, myMin, myMax, vals, []uint64{})
+
+    bvData, err := bvTree.MarshalBinary()
+    if err != nil {
+        panic(err)
+    }
+    var bvCopy bvtree.BvTree
+    if err := bvCopy.UnmarshalBinary(bvData); err != nil {
+        panic(err)
+    }
+    checkTree(&bvCopy, myMin, myMax, vals, []uint64{})
+
+    // Corrupted, truncated and mismatched payloads are all rejected.
+    fhData[len(fhData) - 1] ^= 1
+    if err := fhCopy.UnmarshalBinary(fhData); !errors.Is(err, bvtree.ErrCorrupt) {
+        panic("Decoded a corrupted tree!")
+    }
+    if err := bvCopy.UnmarshalBinary(bvData[:len(bvData) - 8]); !errors.Is(err, bvtree.ErrCorrupt) {
+        panic("Decoded a truncated tree!")
+    }
+    if err := fhCopy.UnmarshalBinary(bvData); !errors.Is(err, bvtree.ErrKindMismatch) {
+        panic("Decoded a BvTree as a BvFhTree!")
+    }
+    fmt.Println(fhCopy.UnmarshalBinary(bvData))
+}
+
+func compressmain() {
+    fmt.Println("Round trip every DynamicSet through the container encoding")
+    numBits := uint64(1 << 18)
+    for _, build := range(builders) {
+        if _, ok := build(64).(*pvebtree.PvEBTree); ok {
+            // A proto-vEB tree of 2^32 is too big to build.
+            continue
+        }
+
+        // A few sparse values, a long run and a dense block.
+        tree := build(numBits)
+        vals := make(map[uint64] bool)
+        for j := 0; j < 200; j++ {
+            n := uint64(rand.Int63n(int64(numBits)))
+            vals[n] = true
+            tree.Insert(n)
+        }
+        for n := uint64(70000); n < 90000; n++ {
+            vals[n] = true
+            tree.Insert(n)
+        }
+        for n := uint64(140000); n < 150000; n += 1 + uint64(rand.Intn(3)) {
+            vals[n] = true
+            tree.Insert(n)
+        }
+
+        data, err := bvtree.MarshalCompressed(tree)
+        if err != nil {
+            panic(err)
+        }
+        fmt.Printf("%d members in %d bytes\n", tree.Len(), len(data))
+
+        // Decode into a different implementation.
+        decoded := vebtree.BuildVEBTree(numBits)
+        if err := bvtree.UnmarshalCompressed(data, decoded); err != nil {
+            panic(err)
+        }
+        checkTree(decoded, tree.Min(), tree.Max(), vals, []uint64{})
+
+        // A set too small for the last members is left empty, not
+        // holding the members before them.
+        small := build(1 << 16)
+        if err := bvtree.UnmarshalCompressed(data, small); !errors.Is(err, bvtree.ErrOutOfUniverse) || small.Len() != 0 {
+            panic(fmt.Sprintf("Decoded into a set too small, %d members went in!", small.Len()))
+        }
+
+        data[len(data) - 3] ^= 0x10
+        if err := bvtree.UnmarshalCompressed(data, build(numBits)); !errors.Is(err, bvtree.ErrCorrupt) {
+            panic("Decoded a corrupted encoding!")
+        }
+    }
+}
+
+/**
+ * Benchmarks the BvFhTree queries over sparse sets, where most of a
+ * successor/predecessor query is spent scanning for the next non
+ * empty cluster. Run with "go run sample.go bench".
+ */
+func roaringmain() {
+    fmt.Println("Check the Roaring portable format against the spec")
+
+    // {1, 2, 3, 1000}: no runs, so cookie 12346, one container with
+    // key 0 and cardinality 4, its offset 16, then the array.
+    tree := bvtree.BuildBvFhTree(1 << 16)
+    for _, n := range([]uint64{1, 2, 3, 1000}) {
+        tree.Insert(n)
+    }
+    golden := []byte{
+        0x3A, 0x30, 0, 0, 1, 0, 0, 0,
+        0, 0, 3, 0,
+        16, 0, 0, 0,
+        1, 0, 2, 0, 3, 0, 0xE8, 0x03,
+    }
+    checkRoaring(tree, golden)
+
+    // [0, 100) and [65536 + 10, 65536 + 15): runs, so cookie 12347
+    // with the number of containers - 1 on top, the run flags and no
+    // offsets as there are fewer than 4 containers.
+    tree = bvtree.BuildBvFhTree(1 << 18)
+    for n := uint64(0); n < 100; n++ {
+        tree.Insert(n)
+    }
+    for n := uint64(65546); n < 65551; n++ {
+        tree.Insert(n)
+    }
+    golden = []byte{
+        0x3B, 0x30, 1, 0,
+        3,
+        0, 0, 99, 0, 1, 0, 4, 0,
+        1, 0, 0, 0, 99, 0,
+        1, 0, 10, 0, 4, 0,
+    }
+    checkRoaring(tree, golden)
+
+    // Every other value of a chunk is a bitmap container, with the
+    // bits numbered from the bottom of each uint64.
+    tree = bvtree.BuildBvFhTree(1 << 16)
+    for n := uint64(0); n < 1 << 16; n += 2 {
+        tree.Insert(n)
+    }
+    golden = []byte{0x3A, 0x30, 0, 0, 1, 0, 0, 0, 0, 0, 0xFF, 0x7F, 16, 0, 0, 0}
+    for i := 0; i < 1024; i++ {
+        golden = append(golden, 0x55, 0x55, 0x55, 0x55, 0x55, 0x55, 0x55, 0x55)
+    }
+    checkRoaring(tree, golden)
+
+    // Enough containers of every kind to need the offsets.
+    numBits := uint64(1 << 22)
+    tree = bvtree.BuildBvFhTree(numBits)
+    vals := make(map[uint64] bool)
+    for j := 0; j < 3000; j++ {
+        n := uint64(rand.Int63n(int64(numBits)))
+        vals[n] = true
+        tree.Insert(n)
+    }
+    for n := uint64(1 << 20); n < 1 << 20 + 50000; n++ {
+        vals[n] = true
+        tree.Insert(n)
+    }
+    for n := uint64(3 << 20); n < 3 << 20 + 60000; n += 1 + uint64(rand.Intn(3)) {
+        vals[n] = true
+        tree.Insert(n)
+    }
+    var buf bytes.Buffer
+    if _, err := tree.WriteRoaringTo(&buf); err != nil {
+        panic(err)
+    }
+    fmt.Printf("%d members in %d bytes\n", tree.Len(), buf.Len())
+    data := buf.Bytes()
+    decoded, err := bvtree.ReadRoaring(bytes.NewReader(data), tree.Universe())
+    if err != nil {
+        panic(err)
+    }
+    checkTree(decoded, tree.Min(), tree.Max(), vals, []uint64{})
+
+    if _, err := bvtree.ReadRoaring(bytes.NewReader(data[:len(data) - 1]), tree.Universe()); !errors.Is(err, bvtree.ErrCorrupt) {
+        panic("Read a truncated Roaring bitmap!")
+    }
+
+    // 18 bytes holding 2^32 - 1 would need a 512MB tree.
+    huge := []byte{0x3A, 0x30, 0, 0, 1, 0, 0, 0, 0xFF, 0xFF, 0, 0, 16, 0, 0, 0, 0xFF, 0xFF}
+    if _, err := bvtree.ReadRoaring(bytes.NewReader(huge), tree.Universe()); !errors.Is(err, bvtree.ErrOutOfUniverse) {
+        panic("Read a Roaring bitmap past the universe it was allowed!")
+    }
+
+    // The examples published with the spec, written by the Java
+    // library from the same bitmap before and after optimizing its
+    // runs.
+    spec := []uint64{}
+    for n := uint64(0); n < 100000; n += 1000 {
+        spec = append(spec, n)
+    }
+    for k := uint64(100000); k < 200000; k++ {
+        spec = append(spec, 3 * k)
+    }
+    for n := uint64(700000); n < 800000; n++ {
+        spec = append(spec, n)
+    }
+    for _, file := range([]struct {
+        path string
+        write func(*bvtree.BvFhTree, io.Writer) (int64, error)
+    }{
+        {"testdata/bitmapwithoutruns.bin", (*bvtree.BvFhTree).WriteRoaringNoRunsTo},
+        {"testdata/bitmapwithruns.bin", (*bvtree.BvFhTree).WriteRoaringTo},
+    }) {
+        golden, err := os.ReadFile(file.path)
+        if err != nil {
+            panic(err)
+        }
+        decoded, err := bvtree.ReadRoaring(bytes.NewReader(golden), 1 << 20)
+        if err != nil {
+            panic(err)
+        }
+        if !slices.Equal(slices.Collect(decoded.All()), spec) {
+            panic(fmt.Sprintf("Read the wrong members from %s!", file.path))
+        }
+        buf.Reset()
+        if _, err := file.write(decoded, &buf); err != nil {
+            panic(err)
+        }
+        if !bytes.Equal(buf.Bytes(), golden) {
+            panic(fmt.Sprintf("Writing %s back out gave different bytes!", file.path))
+        }
+    }
+
+    tree = bvtree.BuildBvFhTree(1 << 34)
+    tree.Insert(1 << 33)
+    if _, err := tree.WriteRoaringTo(&buf); !errors.Is(err, bvtree.ErrOutOfUniverse) {
+        panic("Wrote a value past 32 bits to a Roaring bitmap!")
+    }
+}
+
+func checkRoaring(tree *bvtree.BvFhTree, golden []byte) {
+    var buf bytes.Buffer
+    written, err := tree.WriteRoaringTo(&buf)
+    if err != nil {
+        panic(err)
+    }
+    if written != int64(len(golden)) || !bytes.Equal(buf.Bytes(), golden) {
+        panic(fmt.Sprintf("Wrote %x, expected %x", buf.Bytes(), golden))
+    }
+
+    decoded, err := bvtree.ReadRoaring(bytes.NewReader(golden), tree.Universe())
+    if err != nil {
+        panic(err)
+    }
+    if !slices.Equal(slices.Collect(decoded.All()), slices.Collect(tree.All())) {
+        panic("Read the wrong members from a Roaring bitmap!")
+    }
+}
+
+func mmapmain() {
+    fmt.Println("Map a BvFhTree straight from a file")
+    numBits := uint64(1 << 20)
+    tree := bvtree.BuildBvFhTree(numBits)
+    vals := make(map[uint64] bool)
+    myMin, myMax := numBits, uint64(0)
+    for j := 0; j < 1000; j++ {
+        n := uint64(rand.Int63n(int64(numBits)))
+        vals[n] = true
+        myMin, myMax = min(myMin, n), max(myMax, n)
+        tree.Insert(n)
+    }
+
+    data, err := tree.MarshalBinary()
+    if err != nil {
+        panic(err)
+    }
+    file, err := os.CreateTemp("", "bvfhtree")
+    if err != nil {
+        panic(err)
+    }
+    defer os.Remove(file.Name())
+    if _, err := file.Write(data); err != nil {
+        panic(err)
+    }
+    file.Close()
+
+    mapped, err := bvtree.OpenMappedBvFhTree(file.Name())
+    if err != nil {
+        panic(err)
+    }
+    if err := mapped.Verify(); err != nil {
+        panic(err)
+    }
+    checkTree(mapped, myMin, myMax, vals, []uint64{})
+    if mapped.Rank(myMax) != uint64(len(vals) - 1) {
+        panic("Wrong rank in a mapped tree!")
+    }
+    if k, ok := mapped.Tree().Select(mapped.Tree().Rank(myMax)); !ok || k != myMax {
+        panic("Wrong select through a mapped tree's BvFhTree!")
+    }
+
+    // Every way of changing the tree is refused.
+    if _, err := mapped.InsertChecked(myMax); !errors.Is(err, bvtree.ErrReadOnly) {
+        panic("Inserted into a mapped tree!")
+    }
+    if _, err := mapped.RemoveChecked(myMax); !errors.Is(err, bvtree.ErrReadOnly) {
+        panic("Removed from a mapped tree!")
+    }
+    if err := mapped.Tree().UnionWith(tree); !errors.Is(err, bvtree.ErrReadOnly) {
+        panic("Changed a mapped tree with set algebra!")
+    }
+    func() {
+        defer func() {
+            if err, _ := recover().(error); !errors.Is(err, bvtree.ErrReadOnly) {
+                panic("Removed from a mapped tree!")
+            }
+        }()
+        mapped.Remove(myMin)
+    }()
+
+    // A clone is on the heap and can be changed.
+    clone := mapped.Clone()
+    if !clone.Remove(myMin) || !mapped.Contains(myMin) {
+        panic("Couldn't change a clone of a mapped tree!")
+    }
+
+    // Reading doesn't change it, so it can be combined into others.
+    union, err := mapped.Tree().Union(tree)
+    if err != nil || union.Len() != tree.Len() {
+        panic("Couldn't combine a mapped tree!")
+    }
+    if err := mapped.Close(); err != nil {
+        panic(err)
+    }
+
+    // A corrupted payload opens, as only the header is read, but
+    // doesn't verify.
+    data[len(data) - 1] ^= 1
+    if err := os.WriteFile(file.Name(), data, 0o644); err != nil {
+        panic(err)
+    }
+    mapped, err = bvtree.OpenMappedBvFhTree(file.Name())
+    if err != nil {
+        panic(err)
+    }
+    if err := mapped.Verify(); !errors.Is(err, bvtree.ErrCorrupt) {
+        panic("Verified a corrupted tree!")
+    }
+    mapped.Close()
+
+    // A corrupted header is caught on open.
+    data[8] ^= 1
+    if err := os.WriteFile(file.Name(), data, 0o644); err != nil {
+        panic(err)
+    }
+    if _, err := bvtree.OpenMappedBvFhTree(file.Name()); !errors.Is(err, bvtree.ErrCorrupt) {
+        panic("Mapped a tree with a corrupted header!")
+    }
+}
+
+func syncmain() {
+    fmt.Println("Share a Synchronized set between goroutines")
+    numBits := uint64(1 << 16)
+    set := bvtree.Synchronized(bvtree.BuildBvFhTree(numBits))
+
+    // Every worker tries to insert the same keys, each key is only
+    // inserted once. Readers walk the set at the same time.
+    var inserted, removed atomic.Uint64
+    var wg sync.WaitGroup
+    for w := 0; w < 8; w++ {
+        wg.Add(2)
+        go func() {
+            defer wg.Done()
+            for n := uint64(0); n < numBits; n += 7 {
+                if ok, err := set.InsertIfAbsent(n); err != nil {
+                    panic(err)
+                } else if ok {
+                    inserted.Add(1)
+                }
+            }
+        }()
+        go func() {
+            defer wg.Done()
+            for j := 0; j < 1000; j++ {
+                if succ, ok := set.SuccessorOk(uint64(j)); ok && succ % 7 != 0 {
+                    panic("Found a key nobody inserted!")
+                }
+                for n := range(set.Range(uint64(j), uint64(j) + 100)) {
+                    if n % 7 != 0 {
+                        panic("Found a key nobody inserted!")
+                    }
+                }
+            }
+        }()
+    }
+    wg.Wait()
+    if inserted.Load() != set.Len() || set.Len() != (numBits + 6) / 7 {
+        panic(fmt.Sprintf("Inserted %d keys for %d members!", inserted.Load(), set.Len()))
+    }
+
+    // Do makes moving a key atomic, the set never loses one.
+    for w := 0; w < 8; w++ {
+        wg.Add(2)
+        go func() {
+            defer wg.Done()
+            for n := uint64(0); n < numBits; n += 14 {
+                set.Do(func(tree bvtree.DynamicSet) {
+                    if tree.Remove(n) {
+                        tree.Insert(n + 1)
+                    }
+                })
+            }
+        }()
+        go func() {
+            defer wg.Done()
+            for j := 0; j < 100; j++ {
+                set.View(func(tree bvtree.DynamicSet) {
+                    count := uint64(0)
+                    for range(tree.All()) {
+                        count++
+                    }
+                    if count != tree.Len() || count != (numBits + 6) / 7 {
+                        panic("Lost a key while moving it!")
+                    }
+                })
+            }
+        }()
+    }
+    wg.Wait()
+
+    // Readers call back into the set from their loops while writers
+    // wait for the lock, which mustn't deadlock.
+    for w := 0; w < 8; w++ {
+        wg.Add(2)
+        go func() {
+            defer wg.Done()
+            for n := uint64(0); n < numBits; n++ {
+                if ok, err := set.RemoveIfPresent(n); err != nil {
+                    panic(err)
+                } else if ok {
+                    removed.Add(1)
+                }
+            }
+        }()
+        go func() {
+            defer wg.Done()
+            for j := 0; j < 20; j++ {
+                for n := range(set.All()) {
+                    set.Contains(n + 1)
+                }
+            }
+        }()
+    }
+    wg.Wait()
+    if removed.Load() != inserted.Load() || set.Len() != 0 {
+        panic(fmt.Sprintf("Removed %d keys of %d!", removed.Load(), inserted.Load()))
+    }
+
+    // The loop body can even change the set it's walking.
+    for n := uint64(0); n < 1000; n++ {
+        set.Insert(n)
+    }
+    for n := range(set.All()) {
+        set.Remove(n)
+    }
+    if set.Len() != 0 {
+        panic("Couldn't empty a SyncSet while walking it!")
+    }
+    if _, err := set.InsertIfAbsent(numBits); !errors.Is(err, bvtree.ErrOutOfUniverse) {
+        panic("Inserted a key outside of the universe!")
+    }
+}
+
+func concurrentmain() {
+    fmt.Println("Stress a ConcurrentBvFhTree")
+    numBits := uint64(1 << 12)
+    tree := bvtree.BuildConcurrentBvFhTree(numBits)
+
+    // Every 100th key stays in the tree the whole time, no query may
+    // skip one of them.
+    for n := uint64(0); n < numBits; n += 100 {
+        tree.Insert(n)
+    }
+
+    // Writers own the keys equal to their number mod 8, and churn
+    // them in a few clusters so the clusters keep emptying. Right
+    // after an Insert returns the key has to be visible to every
+    // query, and gone after a Remove.
+    numWriters := 8
+    owned := make([]map[uint64] bool, numWriters)
+    var wg sync.WaitGroup
+    var done atomic.Bool
+    for w := 0; w < numWriters; w++ {
+        owned[w] = make(map[uint64] bool)
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            r := rand.New(rand.NewSource(int64(w)))
+            for j := 0; j < 200000; j++ {
+                n := uint64(r.Intn(int(numBits) / 8)) * 8 + uint64(w)
+                if n % 100 == 0 || n == 0 || n == numBits - 1 {
+                    continue
+                }
+                if owned[w][n] {
+                    if !tree.Remove(n) || tree.Contains(n) {
+                        panic("Couldn't remove an owned key!")
+                    }
+                    delete(owned[w], n)
+                } else {
+                    if !tree.Insert(n) {
+                        panic("Couldn't insert an owned key!")
+                    }
+                    owned[w][n] = true
+                    if succ, ok := tree.SuccessorOk(n - 1); !ok || succ != n {
+                        panic(fmt.Sprintf("Successor of %d skipped %d for %d!", n - 1, n, succ))
+                    }
+                    if pred, ok := tree.PredecessorOk(n + 1); !ok || pred != n {
+                        panic(fmt.Sprintf("Predecessor of %d skipped %d for %d!", n + 1, n, pred))
+                    }
+                }
+            }
+        }()
+    }
+    for w := 0; w < 4; w++ {
+        go func() {
+            r := rand.New(rand.NewSource(int64(100 + w)))
+            for !done.Load() {
+                n := uint64(r.Intn(int(numBits) - 100))
+                if succ, ok := tree.SuccessorOk(n); !ok || succ <= n || succ > (n / 100 + 1) * 100 {
+                    panic(fmt.Sprintf("Successor of %d skipped a stable key for %d!", n, succ))
+                }
+                if pred, ok := tree.PredecessorOk(n + 1); !ok || pred > n || pred < n / 100 * 100 {
+                    panic(fmt.Sprintf("Predecessor of %d skipped a stable key for %d!", n + 1, pred))
+                }
+                if tree.Min() != 0 {
+                    panic("Lost the min!")
+                }
+            }
+        }()
+    }
+    wg.Wait()
+    done.Store(true)
+
+    // Once everything has settled the tree is exact.
+    vals := make(map[uint64] bool)
+    for n := uint64(0); n < numBits; n += 100 {
+        vals[n] = true
+    }
+    for w := 0; w < numWriters; w++ {
+        maps.Copy(vals, owned[w])
+    }
+    checkTree(tree, 0, slices.Max(slices.Collect(maps.Keys(vals))), vals, []uint64{})
+
+    // One goroutine keeps emptying a cluster, so its summary bit is
+    // cleared over and over, while others hold a key in the same
+    // cluster and look for it from the clusters either side, which
+    // have to go through the summary. The clusters of 2^12 keys are
+    // 64 wide.
+    churned := bvtree.BuildConcurrentBvFhTree(numBits)
+    lo, hi := uint64(5 * 64), uint64(6 * 64)
+    var stop atomic.Bool
+    go func() {
+        for !stop.Load() {
+            churned.Insert(lo)
+            churned.Remove(lo)
+        }
+    }()
+    for w := uint64(1); w <= 4; w++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for j := 0; j < 50000; j++ {
+                churned.Insert(lo + w)
+                if succ, ok := churned.SuccessorOk(lo - 2); !ok || succ > lo + w {
+                    panic(fmt.Sprintf("Successor of %d skipped %d for %d!", lo - 2, lo + w, succ))
+                }
+                if pred, ok := churned.PredecessorOk(hi + 1); !ok || pred < lo + w {
+                    panic(fmt.Sprintf("Predecessor of %d skipped %d for %d!", hi + 1, lo + w, pred))
+                }
+                churned.Remove(lo + w)
+            }
+        }()
+    }
+    wg.Wait()
+    stop.Store(true)
+}
+
+func shardedmain() {
+    fmt.Println("Write to every shard of a ShardedSet at once")
+    numBits := uint64(1 << 16)
+    set := bvtree.BuildShardedSet(numBits, 16)
+    width := set.Universe() / 16
+
+    // A member in the middle of every other shard stays put, queries
+    // from any shard have to find them across the empty ones.
+    for i := uint64(0); i < 16; i += 2 {
+        set.Insert(i * width + width / 2)
+    }
+
+    var wg sync.WaitGroup
+    var done atomic.Bool
+    owned := make([]map[uint64] bool, 16)
+    for w := 1; w < 16; w += 2 {
+        owned[w] = make(map[uint64] bool)
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            r := rand.New(rand.NewSource(int64(w)))
+            for j := 0; j < 20000; j++ {
+                n := uint64(w) * width + uint64(r.Int63n(int64(width)))
+                if owned[w][n] {
+                    set.Remove(n)
+                    delete(owned[w], n)
+                } else {
+                    set.Insert(n)
+                    owned[w][n] = true
+                }
+            }
+        }()
+    }
+    for w := 0; w < 4; w++ {
+        go func() {
+            r := rand.New(rand.NewSource(int64(100 + w)))
+            for !done.Load() {
+                n := uint64(r.Int63n(int64(14 * width + width / 2)))
+                stable := (n / width / 2 * 2) * width + width / 2
+                if n >= stable {
+                    stable += 2 * width
+                }
+                if succ, ok := set.SuccessorOk(n); !ok || succ <= n || succ > stable {
+                    panic(fmt.Sprintf("Successor of %d skipped %d for %d!", n, stable, succ))
+                }
+            }
+        }()
+    }
+    wg.Wait()
+    done.Store(true)
+
+    vals := make(map[uint64] bool)
+    for i := uint64(0); i < 16; i += 2 {
+        vals[i * width + width / 2] = true
+    }
+    for w := 1; w < 16; w += 2 {
+        maps.Copy(vals, owned[w])
+    }
+    sorted := slices.Sorted(maps.Keys(vals))
+    checkTree(set, sorted[0], sorted[len(sorted) - 1], vals, []uint64{})
+}
+
+func persistentmain() {
+    fmt.Println("Keep every version of a PersistentSet")
+    numBits := uint64(1 << 24)
+    versions := []*bvtree.PersistentSet{bvtree.BuildPersistentSet(numBits)}
+    contents := []map[uint64] bool{{}}
+    for j := 0; j < 2000; j++ {
+        last := versions[len(versions) - 1]
+        vals := maps.Clone(contents[len(contents) - 1])
+        n := uint64(rand.Int63n(int64(numBits)))
+        if j % 3 == 2 {
+            // Take out one that's there.
+            n = slices.Collect(last.All())[rand.Intn(int(last.Len()))]
+            delete(vals, n)
+            versions = append(versions, last.Remove(n))
+        } else {
+            vals[n] = true
+            versions = append(versions, last.Insert(n))
+        }
+        contents = append(contents, vals)
+    }
+
+    // Every version still holds exactly what it did when it was made.
+    for i, version := range(versions) {
+        want := slices.Sorted(maps.Keys(contents[i]))
+        if version.Len() != uint64(len(want)) || !slices.Equal(slices.Collect(version.All()), want) {
+            panic(fmt.Sprintf("Version %d changed!", i))
+        }
+        for k, n := range(want) {
+            if k > 0 && version.Predecessor(n) != want[k - 1] {
+                panic("Wrong predecessor in an old version!")
+            }
+            if k < len(want) - 1 && version.Successor(n) != want[k + 1] {
+                panic("Wrong successor in an old version!")
+            }
+        }
+    }
+
+    last := versions[len(versions) - 1]
+    if last.Insert(last.Min()) != last {
+        panic("Made a new version without changing anything!")
+    }
+    if n := last.Min() + 1; !last.Contains(n) && last.Remove(n) != last {
+        panic("Made a new version without changing anything!")
+    }
+    if _, err := last.InsertChecked(numBits); !errors.Is(err, bvtree.ErrOutOfUniverse) {
+        panic("Inserted a key outside of the universe!")
+    }
+
+    // A new version only copies the changed cluster and its group,
+    // everything else is shared.
+    n := last.Max() - 1
+    for last.Contains(n) {
+        n--
+    }
+    allocs := testing.AllocsPerRun(100, func() {
+        last.Insert(n)
+    })
+    if allocs > 4 {
+        panic(fmt.Sprintf("A new version allocated %v times!", allocs))
+    }
+}
+
+func clonemain() {
+    fmt.Println("Clone, Clear and reuse trees from a sync.Pool")
+    numBits := uint64(1 << 16)
+    pool := sync.Pool{New: func() any { return bvtree.BuildBvFhTree(numBits) }}
+
+    for round := 0; round < 10; round++ {
+        tree := pool.Get().(*bvtree.BvFhTree)
+        if tree.Len() != 0 || tree.Universe() != numBits {
+            panic("Got a dirty tree from the pool!")
+        }
+        vals := make(map[uint64] bool)
+        for j := 0; j < 100; j++ {
+            n := uint64(rand.Int63n(int64(numBits)))
+            vals[n] = true
+            tree.Insert(n)
+        }
+
+        // The clone doesn't change with the original.
+        clone := tree.Clone()
+        tree.Remove(tree.Min())
+        checkTree(clone, slices.Min(slices.Collect(maps.Keys(vals))), slices.Max(slices.Collect(maps.Keys(vals))), vals, []uint64{})
+
+        tree.Clear()
+        if _, ok := tree.MinOk(); ok || tree.Len() != 0 || tree.Rank(numBits) != 0 {
+            panic("Clear left members behind!")
+        }
+        pool.Put(tree)
+    }
+
+    // Reset reshapes a tree to a new universe.
+    tree := pool.Get().(*bvtree.BvFhTree)
+    tree.Reset(1 << 10)
+    tree.Insert(1 << 10 - 1)
+    if tree.Universe() != 1 << 10 || tree.Max() != 1 << 10 - 1 {
+        panic("Reset to the wrong universe!")
+    }
+
+    bvTree := bvtree.BuildBvTree(numBits)
+    for j := 0; j < 100; j++ {
+        bvTree.Insert(uint64(rand.Int63n(int64(numBits))))
+    }
+    clone := bvTree.Clone()
+    bvTree.Clear()
+    if _, ok := bvTree.MaxOk(); ok || clone.Len() == 0 {
+        panic("Cleared the wrong BvTree!")
+    }
+}
+
+func optionsmain() {
+    fmt.Println("Build sets with options")
+    var logged bytes.Buffer
+    logger := slog.New(slog.NewTextHandler(&logged, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+    members := []uint64{3, 1000, 4000}
+    vals := map[uint64] bool{3: true, 1000: true, 4000: true}
+    for _, impl := range([]bvtree.Implementation{bvtree.ImplBvFhTree, bvtree.ImplBvTree, bvtree.ImplBvMlTree, bvtree.ImplConcurrentBvFhTree}) {
+        logged.Reset()
+        set, err := bvtree.New(4096, bvtree.WithImplementation(impl), bvtree.WithMembers(members...), bvtree.WithLogger(logger))
+        if err != nil {
+            panic(err)
+        }
+        checkTree(set, 3, 4000, vals, []uint64{4})
+        if !strings.Contains(logged.String(), "implementation=" + impl.String()) {
+            panic("Didn't log building the set!")
+        }
+    }
+
+    if _, err := bvtree.NewBvTree(4096, bvtree.WithMembers(4096)); !errors.Is(err, bvtree.ErrOutOfUniverse) {
+        panic("Built a set with a member outside of the universe!")
+    }
+
+    // With room for a bigger universe, Reset doesn't allocate.
+    tree, err := bvtree.NewBvFhTree(1 << 10, bvtree.WithCapacity(1 << 16))
+    if err != nil {
+        panic(err)
+    }
+    allocs := testing.AllocsPerRun(10, func() {
+        tree.Reset(1 << 16)
+        tree.Insert(1 << 16 - 1)
+        tree.Reset(1 << 10)
+        tree.Insert(1 << 10 - 1)
+    })
+    if allocs != 0 || tree.Max() != 1 << 10 - 1 {
+        panic(fmt.Sprintf("Reset allocated %v times!", allocs))
+    }
+}
+
+// A named key type, like the ports the 16 bit sets were made for.
+type port uint16
+
+func genericmain() {
+    fmt.Println("Sets of 8, 16, 32 and 64 bit keys")
+    checkGeneric[uint8](&gbvtree.BvFhTree8[uint8]{}, 256)
+    checkGeneric[port](&gbvtree.BvFhTree16[port]{}, 1 << 16)
+    checkGeneric[uint32](gbvtree.NewBvFhTree[uint32](1 << 20), 1 << 20)
+    checkGeneric[uint64](gbvtree.NewBvTree[uint64](1 << 20), 1 << 20)
+    checkGeneric[uint16](gbvtree.NewBvFhTree[uint16](0), 1 << 16)
+    checkGeneric[uint8](&gbvtree.BvTree8[uint8]{}, 256)
+    checkGeneric[port](&gbvtree.BvTree16[port]{}, 1 << 16)
+    checkGeneric[uint16](gbvtree.NewBvTree[uint16](1 << 12), 1 << 16)
+    if _, ok := gbvtree.NewBvTree[uint8](0).(*gbvtree.BvTree8[uint8]); !ok {
+        panic("NewBvTree didn't use an inline set!")
+    }
+
+    // The small sets are just arrays, using them doesn't allocate.
+    var ports gbvtree.BvFhTree16[port]
+    allocs := testing.AllocsPerRun(100, func() {
+        ports.Insert(443)
+        ports.Insert(8080)
+        if ports.Successor(443) != 8080 || ports.Predecessor(8080) != 443 {
+            panic("Lost a port!")
+        }
+        ports.Remove(8080)
+    })
+    if allocs != 0 {
+        panic(fmt.Sprintf("The inline set allocated %v times!", allocs))
+    }
+    if ports.Universe() != 1 << 16 || (&gbvtree.BvFhTree16[uint8]{}).Universe() != 1 << 8 {
+        panic("Wrong universe for an inline set!")
+    }
+}
+
+func checkGeneric[K gbvtree.Key](set gbvtree.DynamicSet[K], numBits uint64) {
+    if set.Universe() != numBits {
+        panic(fmt.Sprintf("Universe is %d, expected %d!", set.Universe(), numBits))
+    }
+    vals := make(map[K] bool)
+    for j := 0; j < 1000; j++ {
+        n := K(rand.Int63n(int64(numBits)))
+        if set.Insert(n) == vals[n] {
+            panic("Insert returned the wrong thing!")
+        }
+        vals[n] = true
+    }
+    want := slices.Sorted(maps.Keys(vals))
+    if set.Len() != uint64(len(want)) || !slices.Equal(slices.Collect(set.All()), want) {
+        panic("Wrong members in a generic set!")
+    }
+    if set.Min() != want[0] || set.Max() != want[len(want) - 1] {
+        panic("Wrong min or max in a generic set!")
+    }
+    for k := 1; k < len(want); k++ {
+        if set.Successor(want[k - 1]) != want[k] || set.Predecessor(want[k]) != want[k - 1] {
+            panic("Wrong successor or predecessor in a generic set!")
+        }
+    }
+    backward := slices.Collect(set.Backward())
+    slices.Reverse(backward)
+    if !slices.Equal(backward, want) {
+        panic("Wrong members walking a generic set backwards!")
+    }
+
+    // Stored iterators walk the same members every time.
+    for _, seq := range([]iter.Seq[K]{set.All(), set.Backward(), set.Range(want[0], want[len(want) - 1])}) {
+        first := slices.Collect(seq)
+        if second := slices.Collect(seq); len(first) < len(want) - 1 || !slices.Equal(first, second) {
+            panic(fmt.Sprintf("A generic iterator walked %d members, then %d the second time!", len(first), len(second)))
+        }
+    }
+    for _, n := range(want) {
+        if !set.Remove(n) || set.Contains(n) {
+            panic("Couldn't remove from a generic set!")
+        }
+    }
+    if _, ok := set.MinOk(); ok {
+        panic("A generic set wasn't empty!")
+    }
+}
+
+func keyedmain() {
+    fmt.Println("Sets of int64s, float64s and times")
+
+    // Signed keys over every int64, negative ones come first.
+    ints := bvtree.Int64Keys(vebtree.BuildVEBTree(^uint64(0)), math.MinInt64)
+    for _, n := range([]int64{-5, math.MinInt64, 7, 0, math.MaxInt64, -1}) {
+        ints.Insert(n)
+    }
+    want := []int64{math.MinInt64, -5, -1, 0, 7, math.MaxInt64}
+    if !slices.Equal(slices.Collect(ints.All()), want) {
+        panic(fmt.Sprintf("Wrong order of int64s %v!", slices.Collect(ints.All())))
+    }
+    if ints.Successor(-3) != -1 || ints.Predecessor(-1) != -5 || ints.Successor(0) != 7 {
+        panic("Wrong successor or predecessor of an int64!")
+    }
+
+    // A bias fits a small range of int64s into a small universe.
+    biased := bvtree.Int64Keys(bvtree.BuildBvFhTree(1 << 12), -1000)
+    biased.Insert(-1000)
+    biased.Insert(500)
+    if biased.Successor(-2000) != -1000 || biased.Predecessor(1 << 20) != 500 || biased.Contains(-1001) {
+        panic("Wrong answer outside of a biased universe!")
+    }
+    if _, err := biased.InsertChecked(-1001); !errors.Is(err, bvtree.ErrOutOfUniverse) {
+        panic("Inserted an int64 below the bias!")
+    }
+
+    // Floats, including the infinities and both zeroes.
+    prices := bvtree.Float64Keys(vebtree.BuildVEBTree(^uint64(0)))
+    for _, f := range([]float64{19.99, -0.5, math.Inf(1), 0, -1e300, 5e-324, math.Inf(-1), 19.98}) {
+        prices.Insert(f)
+    }
+    wantPrices := []float64{math.Inf(-1), -1e300, -0.5, 0, 5e-324, 19.98, 19.99, math.Inf(1)}
+    if !slices.Equal(slices.Collect(prices.All()), wantPrices) {
+        panic(fmt.Sprintf("Wrong order of float64s %v!", slices.Collect(prices.All())))
+    }
+    if prices.Insert(math.Copysign(0, -1)) || prices.Successor(19.985) != 19.99 || prices.Predecessor(0) != -0.5 {
+        panic("Wrong successor or predecessor of a float64!")
+    }
+    if _, ok := prices.SuccessorOk(math.NaN()); ok || prices.Contains(math.NaN()) {
+        panic("Found a NaN!")
+    }
+    if got := slices.Collect(prices.Range(-1, 19.99)); !slices.Equal(got, []float64{-0.5, 0, 5e-324, 19.98}) {
+        panic(fmt.Sprintf("Wrong range of float64s %v!", got))
+    }
+
+    // Times to the second since an epoch, well past what a
+    // time.Duration can hold.
+    epoch := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+    times := bvtree.TimeKeys(vebtree.BuildVEBTree(^uint64(0)), epoch, time.Second)
+    noon := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+    far := time.Date(3000, 1, 1, 0, 0, 0, 0, time.UTC)
+    times.Insert(noon)
+    times.Insert(far)
+    times.Insert(noon.Add(1500 * time.Millisecond))
+    if !times.Successor(noon).Equal(noon.Add(time.Second)) || !times.Max().Equal(far) {
+        panic("Wrong successor of a time!")
+    }
+    if !times.Successor(epoch.Add(-time.Hour)).Equal(noon) || !times.Contains(noon.Add(300 * time.Millisecond)) {
+        panic("Wrong answer for a time before the epoch!")
+    }
+}
+
+func rsvebmain() {
+    fmt.Println("Sample of a reduced-space vEB tree holding 64 bit IDs")
+    vTree := vebtree.BuildRSVEBTree(^uint64(0))
+    vals := make(map[uint64] bool, 5000)
+    myMin := ^uint64(0)
+    myMax := uint64(0)
+    for j := 0; j < 5000; j++ {
+        n := rand.Uint64()
+        if j % 4 == 0 {
+            // Keep some of the IDs close together.
+            n = (n >> 48) + uint64(j)
+        }
+        vals[n] = true
+        vTree.Insert(n)
+        myMin = min(myMin, n)
+        myMax = max(myMax, n)
+    }
+    checkTree(vTree, myMin, myMax, vals, []uint64{})
+
+    // The nodes should grow with the IDs, not with the universe.
+    if nodes := vTree.NumNodes(); nodes > 4 * uint64(len(vals)) {
+        panic(fmt.Sprintf("%d nodes for %d IDs!", nodes, len(vals)))
+    }
+
+    // Emptying it should drop every cluster and summary.
+    for val, _ := range(vals) {
+        if !vTree.Remove(val) {
+            panic("Remove didn't find a value I put in!")
+        }
+    }
+    if vTree.Len() != 0 || vTree.NumNodes() != 1 {
+        panic("Tree didn't drop its clusters after removing everything!")
+    }
+}
+
+func fasttriemain() {
+    fmt.Println("Sample of x-fast and y-fast tries over 64 bit keys")
+    for _, trie := range([]bvtree.DynamicSet{fasttrie.BuildXFastTrie(^uint64(0)), fasttrie.BuildYFastTrie(^uint64(0))}) {
+        vals := make(map[uint64] bool, 2000)
+        myMin := ^uint64(0)
+        myMax := uint64(0)
+        for j := 0; j < 2000; j++ {
+            n := rand.Uint64()
+            if j % 2 == 0 {
+                // Keep some of the keys close together, so buckets
+                // split and share long prefixes.
+                n = (n >> 52) + uint64(j)
+            }
+            vals[n] = true
+            trie.Insert(n)
+            myMin = min(myMin, n)
+            myMax = max(myMax, n)
+        }
+        // The top of the universe represents the last y-fast bucket
+        // without being a member.
+        ghosts := []uint64{}
+        if !vals[^uint64(0)] {
+            ghosts = append(ghosts, ^uint64(0))
+        }
+        checkTree(trie, myMin, myMax, vals, ghosts)
+
+        // Remove them in order, the buckets merge back down as they go.
+        for _, val := range(slices.Sorted(maps.Keys(vals))) {
+            if !trie.Remove(val) {
+                panic("Remove didn't find a value I put in!")
+            }
+            if min, ok := trie.MinOk(); ok && min <= val {
+                panic("Wrong min after removing the min!")
+            }
+        }
+        if trie.Len() != 0 {
+            panic("Trie isn't empty after removing everything!")
+        }
+    }
+}
+
+func frozenmain() {
+    fmt.Println("Sample of sets frozen with Elias-Fano")
+    for _, build := range(builders) {
+        set := build(14336)
+        vals := make(map[uint64] bool, 300)
+        for j := 0; j < 300; j++ {
+            n := uint64(rand.Intn(14336))
+            vals[n] = true
+            set.Insert(n)
+        }
+        frozen := bvtree.Freeze(set)
+        checkTree(frozen, set.Min(), set.Max(), vals, []uint64{})
+        if !slices.Equal(slices.Collect(frozen.Backward()), slices.Collect(set.Backward())) {
+            panic("A frozen set walks backwards differently!")
+        }
+    }
+
+    // Sparse 64 bit IDs take about 2 + log(u/n) bits each.
+    vTree := vebtree.BuildVEBTree(^uint64(0))
+    vals := make(map[uint64] bool, 5000)
+    for j := 0; j < 5000; j++ {
+        n := rand.Uint64()
+        vals[n] = true
+        vTree.Insert(n)
+    }
+    frozen := bvtree.Freeze(vTree)
+    checkTree(frozen, vTree.Min(), vTree.Max(), vals, []uint64{})
+    if bitsEach := frozen.SizeInBits() / frozen.Len(); bitsEach > 2 + 64 - uint64(bits.Len64(5000)) + 2 {
+        panic(fmt.Sprintf("A frozen set took %d bits per member!", bitsEach))
+    }
+
+    // It can't be changed.
+    if _, err := frozen.InsertChecked(1); !errors.Is(err, bvtree.ErrReadOnly) {
+        panic("Inserted into a frozen set!")
+    }
+    if _, err := frozen.RemoveChecked(vTree.Min()); !errors.Is(err, bvtree.ErrReadOnly) {
+        panic("Removed from a frozen set!")
+    }
+    func() {
+        defer func() {
+            if err, _ := recover().(error); !errors.Is(err, bvtree.ErrReadOnly) {
+                panic("Inserted into a frozen set!")
+            }
+        }()
+        frozen.Insert(1)
+    }()
+    small := bvtree.Freeze(bvtree.BuildBvFhTree(100))
+    if _, err := small.InsertChecked(small.Universe()); !errors.Is(err, bvtree.ErrOutOfUniverse) {
+        panic("Inserted past the universe of a frozen set!")
+    }
+
+    // Freezing an empty set gives an empty set.
+    if _, ok := small.MinOk(); ok || small.Len() != 0 || small.Rank(50) != 0 || small.Contains(0) {
+        panic("A frozen empty set wasn't empty!")
+    }
+    for _ = range(small.All()) {
+        panic("Walked a frozen empty set!")
+    }
+}
+
+func benchmain() {
+    for _, numBits := range([]uint64{1 << 20, 1 << 26}) {
+        bvTree := bvtree.BuildBvFhTree(numBits)
+        for i := 0; i < 1024; i++ {
+            bvTree.Insert(uint64(rand.Int63n(int64(numBits))))
+        }
+        reference := buildBitScanTree(bvTree)
+
+        queries := make([]uint64, 4096)
+        for i := range(queries) {
+            queries[i] = uint64(rand.Int63n(int64(numBits)))
+        }
+
+        // Both have to agree before their timings mean anything.
+        for _, n := range(queries) {
+            s1, ok1 := bvTree.SuccessorOk(n)
+            s2, ok2 := reference.successorOk(n)
+            p1, ok3 := bvTree.PredecessorOk(n)
+            p2, ok4 := reference.predecessorOk(n)
+            if s1 != s2 || ok1 != ok2 || p1 != p2 || ok3 != ok4 {
+                panic(fmt.Sprintf("Scanning bit by bit disagrees at %d!", n))
+            }
+        }
+
+        benches := []struct {
+            name string
+            words func(n uint64)
+            bits func(n uint64)
+        }{
+            {"SuccessorOk", func(n uint64) { bvTree.SuccessorOk(n) }, func(n uint64) { reference.successorOk(n) }},
+            {"PredecessorOk", func(n uint64) { bvTree.PredecessorOk(n) }, func(n uint64) { reference.predecessorOk(n) }},
+            {"MinOk", func(n uint64) { bvTree.MinOk() }, func(n uint64) { reference.minOk() }},
+            {"MaxOk", func(n uint64) { bvTree.MaxOk() }, func(n uint64) { reference.maxOk() }},
+        }
+        for _, bench := range(benches) {
+            before := benchQueries(bench.bits, queries)
+            after := benchQueries(bench.words, queries)
+            fmt.Printf("2^%d %-14s bit by bit %8d ns/op  words %6d ns/op  %5.1fx\n", bits.Len64(numBits) - 1, bench.name,
+                before.NsPerOp(), after.NsPerOp(), float64(before.NsPerOp()) / float64(max(after.NsPerOp(), 1)))
+        }
+    }
+}
+
+func benchQueries(query func(n uint64), queries []uint64) testing.BenchmarkResult {
+    return testing.Benchmark(func(b *testing.B) {
+        for i := 0; i < b.N; i++ {
+            query(queries[i % len(queries)])
+        }
+    })
+}
+
+/**
+ * bitScanTree is the layout of a BvFhTree searched one bit at a
+ * time, the way Successor and Predecessor worked before they
+ * scanned whole words. It's only the baseline for benchmain.
+ */
+type bitScanTree struct {
+    numBits uint64
+    sqNumBits uint64
+    summary []uint64
+    bitvector []uint64
+}
+
+func buildBitScanTree(bvTree *bvtree.BvFhTree) *bitScanTree {
+    numBits := bvTree.Universe()
+    sqNumBits := uint64(2)
+    for sqNumBits * sqNumBits < numBits {
+        sqNumBits *= 2
+    }
+    result := &bitScanTree{
+        numBits: numBits,
+        sqNumBits: sqNumBits,
+        summary: make([]uint64, (sqNumBits + 63) / 64),
+        bitvector: make([]uint64, (numBits + 63) / 64),
+    }
+    for n := range(bvTree.All()) {
+        setBit(result.bitvector, n)
+        setBit(result.summary, n / sqNumBits)
+    }
+    return result
+}
+
+func setBit(words []uint64, pos uint64) {
+    words[pos / 64] |= uint64(1 << (63 - pos % 64))
+}
+
+func hasBit(words []uint64, pos uint64) bool {
+    return words[pos / 64] & uint64(1 << (63 - pos % 64)) != 0
+}
+
+func (tree *bitScanTree) minOk() (uint64, bool) {
+    for i := uint64(0); i < tree.sqNumBits; i++ {
+        if hasBit(tree.summary, i) {
+            return tree.clusterMin(i)
+        }
+    }
+    return 0, false
+}
+
+func (tree *bitScanTree) maxOk() (uint64, bool) {
+    for i := tree.sqNumBits; i > 0; i-- {
+        if hasBit(tree.summary, i - 1) {
+            return tree.clusterMax(i - 1)
+        }
+    }
+    return 0, false
+}
+
+func (tree *bitScanTree) successorOk(n uint64) (uint64, bool) {
+    if n >= tree.numBits {
+        return 0, false
+    }
+    max := min((n / tree.sqNumBits + 1) * tree.sqNumBits, tree.numBits)
+    for i := n + 1; i < max; i++ {
+        if hasBit(tree.bitvector, i) {
+            return i, true
+        }
+    }
+    for i := n / tree.sqNumBits + 1; i < tree.sqNumBits; i++ {
+        if hasBit(tree.summary, i) {
+            return tree.clusterMin(i)
+        }
+    }
+    return 0, false
+}
+
+func (tree *bitScanTree) predecessorOk(n uint64) (uint64, bool) {
+    if n >= tree.numBits {
+        return tree.maxOk()
+    }
+    min := (n / tree.sqNumBits) * tree.sqNumBits
+    for i := n; i > min; i-- {
+        if hasBit(tree.bitvector, i - 1) {
+            return i - 1, true
+        }
+    }
+    for i := n / tree.sqNumBits; i > 0; i-- {
+        if hasBit(tree.summary, i - 1) {
+            return tree.clusterMax(i - 1)
+        }
+    }
+    return 0, false
+}
+
+func (tree *bitScanTree) clusterMin(sIdx uint64) (uint64, bool) {
+    max := min((sIdx + 1) * tree.sqNumBits, tree.numBits)
+    for i := sIdx * tree.sqNumBits; i < max; i++ {
+        if hasBit(tree.bitvector, i) {
+            return i, true
+        }
+    }
+    return 0, false
+}
+
+func (tree *bitScanTree) clusterMax(sIdx uint64) (uint64, bool) {
+    for i := min((sIdx + 1) * tree.sqNumBits, tree.numBits); i > sIdx * tree.sqNumBits; i-- {
+        if hasBit(tree.bitvector, i - 1) {
+            return i - 1, true
+        }
+    }
+    return 0, false
+}
+
 func checkTree(bvTree bvtree.DynamicSet, myMin uint64, myMax uint64, vals map[uint64] bool, ghosts []uint64) {
         fmt.Println(bvTree)
         fmt.Printf("min/max were %d/%d\n ", bvTree.Min(), bvTree.Max())
@@ -154,5 +1557,132 @@ func checkTree(bvTree bvtree.DynamicSet, myMin uint64, myMax uint64, vals map[ui
                 panic ("didn't have a value I put in!")
             }
         }
+
+        if bvTree.Len() != uint64(len(vals)) {
+            panic(fmt.Sprintf("Len() was %d, put in %d values\n", bvTree.Len(), len(vals)))
+        }
+
+        // Keys past the end of the universe are errors, not crashes.
+        if u := bvTree.Universe(); u != 0 {
+            if bvTree.Contains(u) {
+                panic("contains a value outside of the universe!")
+            }
+            if _, err := bvTree.InsertChecked(u); !errors.Is(err, bvtree.ErrOutOfUniverse) {
+                panic("inserted a value outside of the universe!")
+            }
+            if _, err := bvTree.ContainsChecked(u - 1); err != nil {
+                panic("last value of the universe was out of bounds!")
+            }
+        }
+
+        // Walk off both ends of the set, without knowing min/max.
+        count := 0
+        cur, ok := bvTree.MinOk()
+        for ok {
+            if !vals[cur] {
+                panic("had a value i didn't put in!")
+            }
+            count++
+            cur, ok = bvTree.SuccessorOk(cur)
+        }
+        if count != len(vals) {
+            panic("SuccessorOk didn't walk the whole set...")
+        }
+
+        count = 0
+        cur, ok = bvTree.MaxOk()
+        for ok {
+            if !vals[cur] {
+                panic("had a value i didn't put in!")
+            }
+            count++
+            cur, ok = bvTree.PredecessorOk(cur)
+        }
+        if count != len(vals) {
+            panic("PredecessorOk didn't walk the whole set...")
+        }
+
+        // The iterators should see the same values, in order.
+        count = 0
+        for n := range(bvTree.All()) {
+            if (count > 0 && n <= cur) || !vals[n] {
+                panic("All() gave a value out of order, or one i didn't put in!")
+            }
+            cur = n
+            count++
+        }
+        if count != len(vals) {
+            panic("All() didn't walk the whole set...")
+        }
+
+        count = 0
+        for n := range(bvTree.Backward()) {
+            if (count > 0 && n >= cur) || !vals[n] {
+                panic("Backward() gave a value out of order, or one i didn't put in!")
+            }
+            cur = n
+            count++
+        }
+        if count != len(vals) {
+            panic("Backward() didn't walk the whole set...")
+        }
+
+        lo, hi := myMin + (myMax - myMin) / 4, myMax - (myMax - myMin) / 4
+        count = 0
+        for n := range(bvTree.Range(lo, hi)) {
+            if n < lo || n >= hi || !vals[n] {
+                panic("Range() gave a value outside of the range!")
+            }
+            count++
+        }
+        for val, _ := range(vals) {
+            if val >= lo && val < hi {
+                count--
+            }
+        }
+        if count != 0 {
+            panic("Range() didn't walk the whole range...")
+        }
+
+        // Breaking out early must stop the walk.
+        for _ = range(bvTree.All()) {
+            break
+        }
+
+        // A stored iterator must walk the same members every time
+        // it's ranged over.
+        for _, walk := range([]struct {
+            name string
+            seq iter.Seq[uint64]
+            count uint64
+        }{
+            {"All", bvTree.All(), bvTree.Len()},
+            {"Backward", bvTree.Backward(), bvTree.Len()},
+            {"Range", bvTree.Range(myMin, myMax), bvTree.Len() - 1},
+        }) {
+            first := slices.Collect(walk.seq)
+            second := slices.Collect(walk.seq)
+            if uint64(len(first)) != walk.count || !slices.Equal(first, second) {
+                panic(fmt.Sprintf("%s() walked %d members, then %d the second time!", walk.name, len(first), len(second)))
+            }
+        }
+
+        if ranked, ok := bvTree.(bvtree.Ranked); ok {
+            sorted := slices.Sorted(maps.Keys(vals))
+            for k, val := range(sorted) {
+                if ranked.Rank(val) != uint64(k) {
+                    panic("Rank() was off!")
+                }
+                if sel, ok := ranked.Select(uint64(k)); !ok || sel != val {
+                    panic("Select() was off!")
+                }
+            }
+            if _, ok := ranked.Select(uint64(len(sorted))); ok {
+                panic("Select() found a value past the end!")
+            }
+            if ranked.Rank(myMax + 1) != uint64(len(sorted)) {
+                panic("Rank() past the max was off!")
+            }
+        }
 }
 
@@ -0,0 +1,38 @@
+package vebtree
+
+/**
+ * Builds a reduced-space van Emde Boas tree (CLRS problem 20-1), a
+ * set of integers between 0 and n for any n up to 2^64.
+ *
+ * It is a VEBTree with the cluster table of every node replaced by
+ * a hash map, holding only the clusters that have members. Clusters
+ * and summaries are created on the first insert into them and
+ * dropped when they empty, so the tree takes O(n) memory whatever
+ * the universe, and a few thousand 64 bit IDs take a few thousand
+ * nodes. Queries are still O(log log u), with a map lookup in
+ * place of an index at each level.
+ */
+func BuildRSVEBTree(numBits uint64) *VEBTree {
+    return buildTree(numBits, true)
+}
+
+// A map of the non empty clusters.
+type mapClusters map[uint64]*vebNode
+
+func (clusters mapClusters) get(high uint64) *vebNode {
+    return clusters[high]
+}
+
+func (clusters mapClusters) put(high uint64, c *vebNode) {
+    clusters[high] = c
+}
+
+func (clusters mapClusters) drop(high uint64) {
+    delete(clusters, high)
+}
+
+func (clusters mapClusters) each(f func(*vebNode)) {
+    for _, c := range(clusters) {
+        f(c)
+    }
+}
@@ -0,0 +1,578 @@
+package vebtree
+
+import (
+    "../bvtree"
+    "fmt"
+    "iter"
+    "math/bits"
+)
+
+/**
+ * VEBTree is a van Emde Boas tree (CLRS 20.3) holding a set
+ * of integers between 0 and n, for any n up to 2^64.
+ *
+ * Each node keeps its min and max outside of its clusters, so
+ * Insert, Remove, Successor and Predecessor only ever recurse
+ * into one child, giving O(log log u) operations. Where BvFhTree
+ * scans its summary bit by bit to find the next non empty cluster,
+ * here the summary is itself a vEB tree and is searched recursively.
+ *
+ * The clusters of a node are kept in a clusterStore, a table indexed
+ * by their high bits for BuildVEBTree, or a hash map holding only the
+ * non empty ones for BuildRSVEBTree.
+ */
+type VEBTree struct {
+
+    // The number of bits in a key, the universe is 2^w.
+    w uint
+
+    // The number of members in the set.
+    count uint64
+
+    root *vebNode
+}
+
+/**
+ * vebNode is one level of the vEB tree, covering a universe of 2^w.
+ */
+type vebNode struct {
+
+    // The number of bits in a key below this node.
+    w uint
+
+    // The number of bits of a key that index into the cluster.
+    lowBits uint
+
+    empty bool
+
+    // Min is never stored in a cluster, max is also stored in one
+    // unless it is equal to min.
+    min uint64
+    max uint64
+
+    // Base case (w <= leafBits), the members as a single word.
+    leaf uint64
+
+    // Whether the clusters are kept in a map rather than a table.
+    sparse bool
+
+    // The summary of which clusters are non empty, and the non empty
+    // clusters, each covering 2^lowBits. Both are nil while the node
+    // holds only its min.
+    summary *vebNode
+    cluster clusterStore
+}
+
+// Nodes with at most this many key bits store their members in a word.
+const leafBits = 6
+
+// The most key bits used to index the clusters of a single node. This
+// keeps the cluster table of a 2^64 universe at 2^16 entries instead
+// of 2^32, at the cost of one more level of recursion.
+const maxHighBits = 16
+
+/**
+ * clusterStore holds the non empty clusters of a node by their high
+ * bits, get returns nil for a cluster that isn't there.
+ */
+type clusterStore interface {
+    get(high uint64) *vebNode
+    put(high uint64, c *vebNode)
+    drop(high uint64)
+    each(f func(*vebNode))
+}
+
+// A table of every cluster, nil where they're empty.
+type tableClusters []*vebNode
+
+func (table tableClusters) get(high uint64) *vebNode {
+    return table[high]
+}
+
+func (table tableClusters) put(high uint64, c *vebNode) {
+    table[high] = c
+}
+
+func (table tableClusters) drop(high uint64) {
+    table[high] = nil
+}
+
+func (table tableClusters) each(f func(*vebNode)) {
+    for _, c := range(table) {
+        if c != nil {
+            f(c)
+        }
+    }
+}
+
+func (vTree *VEBTree) Min() uint64 {
+    min, ok := vTree.MinOk()
+    if !ok {
+        panic(bvtree.ErrEmpty)
+    }
+    return min
+}
+
+func (vTree *VEBTree) MinOk() (uint64, bool) {
+    return vTree.root.min, !vTree.root.empty
+}
+
+func (vTree *VEBTree) Max() uint64 {
+    max, ok := vTree.MaxOk()
+    if !ok {
+        panic(bvtree.ErrEmpty)
+    }
+    return max
+}
+
+func (vTree *VEBTree) MaxOk() (uint64, bool) {
+    return vTree.root.max, !vTree.root.empty
+}
+
+/**
+ * Returns the number below n in the tree.
+ * Assumes that the number passed in is greater than
+ * the min value.
+ */
+func (vTree *VEBTree) Predecessor(n uint64) uint64 {
+    pred, ok := vTree.PredecessorOk(n)
+    if !ok {
+        panic("There was a problem with predecessor.")
+    }
+    return pred
+}
+
+/**
+ * Returns the number below n in the tree, and false if
+ * there is no such number.
+ */
+func (vTree *VEBTree) PredecessorOk(n uint64) (uint64, bool) {
+    // Everything in the tree is below n.
+    if !vTree.inUniverse(n) {
+        return vTree.MaxOk()
+    }
+    return vTree.root.predecessor(n)
+}
+
+/**
+ * Returns the number above n in the tree.
+ * Assumes that the number passed in is less than
+ * the max value.
+ */
+func (vTree *VEBTree) Successor(n uint64) uint64 {
+    succ, ok := vTree.SuccessorOk(n)
+    if !ok {
+        panic("There was a problem with successor.")
+    }
+    return succ
+}
+
+/**
+ * Returns the number above n in the tree, and false if
+ * there is no such number.
+ */
+func (vTree *VEBTree) SuccessorOk(n uint64) (uint64, bool) {
+    if !vTree.inUniverse(n) {
+        return 0, false
+    }
+    return vTree.root.successor(n)
+}
+
+// Returns true if n fits in the w bits of the tree's keys.
+func (vTree *VEBTree) inUniverse(n uint64) bool {
+    return vTree.w == 64 || n >> vTree.w == 0
+}
+
+/**
+ * returns true if the tree contains the given uint64.
+ */
+func (vTree *VEBTree) Contains(n uint64) bool {
+    return vTree.inUniverse(n) && vTree.root.member(n)
+}
+
+/**
+ * Inserts the integer n into the tree, returns true if
+ * it wasn't already there.
+ */
+func (vTree *VEBTree) Insert(n uint64) bool {
+    added, err := vTree.InsertChecked(n)
+    if err != nil {
+        panic(err)
+    }
+    return added
+}
+
+/**
+ * Removes the integer n from the tree, returns true if
+ * it was there.
+ */
+func (vTree *VEBTree) Remove(n uint64) bool {
+    // The vEB delete assumes n is a member.
+    if !vTree.Contains(n) {
+        return false
+    }
+    vTree.root.remove(n)
+    vTree.count--
+    return true
+}
+
+/**
+ * returns true if the tree contains n, or an error if n
+ * is outside of the universe.
+ */
+func (vTree *VEBTree) ContainsChecked(n uint64) (bool, error) {
+    if err := bvtree.CheckUniverse(n, vTree.Universe()); err != nil {
+        return false, err
+    }
+    return vTree.root.member(n), nil
+}
+
+/**
+ * Inserts n, returning an error instead of panicking if n
+ * is outside of the universe.
+ */
+func (vTree *VEBTree) InsertChecked(n uint64) (bool, error) {
+    if err := bvtree.CheckUniverse(n, vTree.Universe()); err != nil {
+        return false, err
+    }
+
+    // The vEB insert assumes n isn't already a member.
+    if vTree.root.member(n) {
+        return false, nil
+    }
+    vTree.root.insert(n)
+    vTree.count++
+    return true, nil
+}
+
+/**
+ * Removes n, returning an error if n is outside of the universe.
+ */
+func (vTree *VEBTree) RemoveChecked(n uint64) (bool, error) {
+    if err := bvtree.CheckUniverse(n, vTree.Universe()); err != nil {
+        return false, err
+    }
+    return vTree.Remove(n), nil
+}
+
+/**
+ * Returns the number of members in the tree.
+ */
+func (vTree *VEBTree) Len() uint64 {
+    return vTree.count
+}
+
+/**
+ * Returns an iterator over the members in ascending order.
+ */
+func (vTree *VEBTree) All() iter.Seq[uint64] {
+    return bvtree.Ascend(vTree, 0, vTree.Universe() - 1)
+}
+
+/**
+ * Returns an iterator over the members in descending order.
+ */
+func (vTree *VEBTree) Backward() iter.Seq[uint64] {
+    return bvtree.Descend(vTree, 0, vTree.Universe() - 1)
+}
+
+/**
+ * Returns an iterator over the members in [lo, hi), in
+ * ascending order.
+ */
+func (vTree *VEBTree) Range(lo uint64, hi uint64) iter.Seq[uint64] {
+    if hi == 0 {
+        return func(yield func(uint64) bool) {}
+    }
+    return bvtree.Ascend(vTree, lo, hi - 1)
+}
+
+/**
+ * Returns the size of the universe, 2^w. A tree over all
+ * 64 bit keys returns 0.
+ */
+func (vTree *VEBTree) Universe() uint64 {
+    return uint64(1) << vTree.w
+}
+
+/**
+ * Returns the number of bits needed for a key in a
+ * universe of numBits values.
+ */
+func getVEBBits(numBits uint64) uint {
+    if numBits <= 1 {
+        return 1
+    }
+    return uint(bits.Len64(numBits - 1))
+}
+
+func BuildVEBTree(numBits uint64) *VEBTree {
+    return buildTree(numBits, false)
+}
+
+func buildTree(numBits uint64, sparse bool) *VEBTree {
+    result := VEBTree{}
+    result.w = getVEBBits(numBits)
+    result.root = buildVEBNode(result.w, sparse)
+    return &result
+}
+
+func buildVEBNode(w uint, sparse bool) *vebNode {
+    node := vebNode{w: w, empty: true, sparse: sparse}
+    if w > leafBits {
+        highBits := (w + 1) / 2
+        if highBits > maxHighBits && !sparse {
+            highBits = maxHighBits
+        }
+        node.lowBits = w - highBits
+    }
+    return &node
+}
+
+func (node *vebNode) newClusters() clusterStore {
+    if node.sparse {
+        return make(mapClusters)
+    }
+    return make(tableClusters, uint64(1) << (node.w - node.lowBits))
+}
+
+func (node *vebNode) isLeaf() bool {
+    return node.w <= leafBits
+}
+
+func (node *vebNode) high(x uint64) uint64 {
+    return x >> node.lowBits
+}
+
+func (node *vebNode) low(x uint64) uint64 {
+    return x & ((uint64(1) << node.lowBits) - 1)
+}
+
+func (node *vebNode) index(x uint64, y uint64) uint64 {
+    return (x << node.lowBits) | y
+}
+
+func (node *vebNode) member(x uint64) bool {
+    if node.empty {
+        return false
+    }
+    if x == node.min || x == node.max {
+        return true
+    }
+    if node.isLeaf() {
+        return node.leaf & uint64(1 << (63 - x)) != 0
+    }
+    if node.cluster == nil {
+        return false
+    }
+    c := node.cluster.get(node.high(x))
+    return c != nil && c.member(node.low(x))
+}
+
+func (node *vebNode) emptyInsert(x uint64) {
+    node.min = x
+    node.max = x
+    node.empty = false
+    if node.isLeaf() {
+        node.leaf = uint64(1 << (63 - x))
+    }
+}
+
+/**
+ * Inserts x below this node, x must not already be a member.
+ */
+func (node *vebNode) insert(x uint64) {
+    if node.empty {
+        node.emptyInsert(x)
+        return
+    }
+
+    if node.isLeaf() {
+        node.leaf |= uint64(1 << (63 - x))
+        node.setLeafBounds()
+        return
+    }
+
+    // The min is kept out of the clusters, so push the old one down.
+    if x < node.min {
+        x, node.min = node.min, x
+    }
+
+    if node.cluster == nil {
+        node.cluster = node.newClusters()
+        node.summary = buildVEBNode(node.w - node.lowBits, node.sparse)
+    }
+
+    high, low := node.high(x), node.low(x)
+    if c := node.cluster.get(high); c != nil {
+        c.insert(low)
+    } else {
+        c = buildVEBNode(node.lowBits, node.sparse)
+        c.emptyInsert(low)
+        node.cluster.put(high, c)
+        node.summary.insert(high)
+    }
+
+    if x > node.max {
+        node.max = x
+    }
+}
+
+/**
+ * Removes x from below this node, x must be a member.
+ */
+func (node *vebNode) remove(x uint64) {
+    if node.min == node.max {
+        node.empty = true
+        node.leaf = 0
+        return
+    }
+
+    if node.isLeaf() {
+        node.leaf &= ^uint64(1 << (63 - x))
+        node.setLeafBounds()
+        return
+    }
+
+    // Removing the min, pull the smallest clustered member up to replace it.
+    if x == node.min {
+        firstCluster := node.summary.min
+        x = node.index(firstCluster, node.cluster.get(firstCluster).min)
+        node.min = x
+    }
+
+    high := node.high(x)
+    c := node.cluster.get(high)
+    c.remove(node.low(x))
+
+    if c.empty {
+        // Drop the empty cluster so sparse trees give the memory back,
+        // and the summary along with the last one.
+        node.cluster.drop(high)
+        node.summary.remove(high)
+        if node.summary.empty {
+            node.summary = nil
+            node.cluster = nil
+            node.max = node.min
+        } else if x == node.max {
+            maxCluster := node.summary.max
+            node.max = node.index(maxCluster, node.cluster.get(maxCluster).max)
+        }
+    } else if x == node.max {
+        node.max = node.index(high, c.max)
+    }
+}
+
+func (node *vebNode) setLeafBounds() {
+    node.min = uint64(bits.LeadingZeros64(node.leaf))
+    node.max = uint64(63 - bits.TrailingZeros64(node.leaf))
+}
+
+func (node *vebNode) successor(x uint64) (uint64, bool) {
+    if node.empty {
+        return 0, false
+    }
+
+    if node.isLeaf() {
+        rest := node.leaf & (^uint64(0) >> (x + 1))
+        if rest == 0 {
+            return 0, false
+        }
+        return uint64(bits.LeadingZeros64(rest)), true
+    }
+
+    if x < node.min {
+        return node.min, true
+    }
+
+    // A node without clusters only holds its min.
+    if node.cluster == nil {
+        return 0, false
+    }
+
+    // First look inside x's own cluster.
+    high, low := node.high(x), node.low(x)
+    c := node.cluster.get(high)
+    if c != nil && low < c.max {
+        offset, _ := c.successor(low)
+        return node.index(high, offset), true
+    }
+
+    // Otherwise the answer is the min of the next non empty cluster.
+    succCluster, ok := node.summary.successor(high)
+    if !ok {
+        return 0, false
+    }
+    return node.index(succCluster, node.cluster.get(succCluster).min), true
+}
+
+func (node *vebNode) predecessor(x uint64) (uint64, bool) {
+    if node.empty {
+        return 0, false
+    }
+
+    if node.isLeaf() {
+        rest := node.leaf & ^(^uint64(0) >> x)
+        if rest == 0 {
+            return 0, false
+        }
+        return uint64(63 - bits.TrailingZeros64(rest)), true
+    }
+
+    if x > node.max {
+        return node.max, true
+    }
+
+    // A node without clusters only holds its min, and x <= max == min.
+    if node.cluster == nil {
+        return 0, false
+    }
+
+    // First look inside x's own cluster.
+    high, low := node.high(x), node.low(x)
+    c := node.cluster.get(high)
+    if c != nil && low > c.min {
+        offset, _ := c.predecessor(low)
+        return node.index(high, offset), true
+    }
+
+    // Otherwise the answer is the max of the previous non empty cluster,
+    // or the min, which lives outside of the clusters.
+    predCluster, ok := node.summary.predecessor(high)
+    if !ok {
+        if x > node.min {
+            return node.min, true
+        }
+        return 0, false
+    }
+    return node.index(predCluster, node.cluster.get(predCluster).max), true
+}
+
+/**
+ * Returns the number of nodes in the tree, summaries included.
+ */
+func (vTree *VEBTree) NumNodes() uint64 {
+    return vTree.root.numNodes()
+}
+
+func (node *vebNode) numNodes() uint64 {
+    result := uint64(1)
+    if node.summary != nil {
+        result += node.summary.numNodes()
+        node.cluster.each(func(c *vebNode) {
+            result += c.numNodes()
+        })
+    }
+    return result
+}
+
+func (vTree *VEBTree) DbgPrint() {
+    fmt.Println("DbgPrint: ")
+    fmt.Printf("universe 2^%d, %d nodes\n", vTree.w, vTree.NumNodes())
+    cur, ok := vTree.MinOk()
+    for ok {
+        fmt.Printf("%d ", cur)
+        cur, ok = vTree.root.successor(cur)
+    }
+    fmt.Println(" ")
+}